@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"gocopilot/internal/permission"
+)
+
+// maxApprovalArgsRunes bounds the arguments shown in an approval prompt.
+const maxApprovalArgsRunes = 500
+
+// ConsolePrompter asks for tool approval on the terminal, sharing stdin with
+// ConsoleInputProvider.
+type ConsolePrompter struct {
+	lines <-chan string
+}
+
+func (p *ConsolePrompter) Confirm(ctx context.Context, req permission.Request) (permission.Answer, error) {
+	args := string(req.Arguments)
+	if utf8.RuneCountInString(args) > maxApprovalArgsRunes {
+		args = string([]rune(args)[:maxApprovalArgsRunes]) + "…"
+	}
+
+	fmt.Printf("\u001b[35m🔐 Allow %s(%s)?\u001b[0m [y]es / [n]o / [a]lways for this session: ", req.Tool, args)
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			return permission.AnswerNo, io.EOF
+		}
+		return permission.ParseAnswer(line), nil
+	case <-ctx.Done():
+		fmt.Println()
+		return permission.AnswerNo, ctx.Err()
+	}
+}
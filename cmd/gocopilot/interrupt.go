@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"gocopilot/internal/agent"
+)
+
+// exitWindow is how soon a second Ctrl-C has to follow the first to exit.
+const exitWindow = 2 * time.Second
+
+// exitSaveTimeout bounds how long exiting waits for the session to be saved.
+const exitSaveTimeout = 3 * time.Second
+
+// watchInterrupts makes Ctrl-C cancel the current turn instead of killing the
+// process. A second Ctrl-C within exitWindow saves the session, runs onExit
+// and exits.
+func watchInterrupts(gocopilot *agent.Agent, onExit func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	go func() {
+		var last time.Time
+		for range signals {
+			if time.Since(last) < exitWindow {
+				fmt.Println()
+				saveBeforeExit(gocopilot)
+				onExit()
+				os.Exit(exitInterrupted)
+			}
+			last = time.Now()
+
+			if gocopilot.CancelTurn() {
+				fmt.Println("\n\u001b[33m⏹ Cancelling the current turn, press Ctrl-C again to exit\u001b[0m")
+			} else {
+				fmt.Print("\n\u001b[33mPress Ctrl-C again to exit\u001b[0m\n\u001b[1;34m💬 You\u001b[0m: ")
+			}
+		}
+	}()
+}
+
+// saveBeforeExit autosaves the session, giving up after exitSaveTimeout so a
+// stuck disk cannot keep the process from exiting.
+func saveBeforeExit(gocopilot *agent.Agent) {
+	saved := make(chan struct{})
+	go func() {
+		gocopilot.SaveBeforeExit()
+		close(saved)
+	}()
+
+	select {
+	case <-saved:
+	case <-time.After(exitSaveTimeout):
+		fmt.Println("\u001b[33mSaving the session timed out, exiting without it\u001b[0m")
+	}
+}
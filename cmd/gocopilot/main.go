@@ -3,9 +3,14 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/signal"
+	"path/filepath"
 
 	"github.com/joho/godotenv"
 	"github.com/openai/openai-go/v3"
@@ -14,16 +19,24 @@ import (
 	"gocopilot/internal/agent"
 	"gocopilot/internal/config"
 	"gocopilot/internal/logger"
+	"gocopilot/internal/permission"
+	"gocopilot/internal/session"
 	"gocopilot/internal/tools"
 )
 
 func main() {
     verbose := flag.Bool("verbose", false, "enable verbose logging")
     reasoning := flag.Bool("reasoning", false, "enable multi-step reasoning chain")
+	noStream := flag.Bool("no-stream", false, "disable streaming of assistant responses")
+	listSessions := flag.Bool("sessions", false, "list saved sessions and exit")
+	resume := flag.String("resume", "", "resume a saved session by ID, or \"last\" for the most recent one")
+	prompt := flag.String("p", "", "run a single prompt non-interactively and print the final answer")
+	jsonOutput := flag.Bool("json", false, "print the final answer of a non-interactive run as JSON")
+	permissionMode := flag.String("permissions", "", "tool approval mode: ask, ask-mutating, auto or deny (overrides PERMISSION_MODE)")
     flag.Parse()
 
-	// Load configuration
-	if err := godotenv.Load(); err != nil {
+	// Load configuration; a missing .env is fine when the environment is set directly (e.g. in CI)
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		fmt.Printf("Error loading .env file: %v\n", err)
 		os.Exit(1)
 	}
@@ -31,6 +44,21 @@ func main() {
     cfg := config.Load()
     cfg.Verbose = *verbose
     cfg.ReasoningEnabled = *reasoning
+	if *noStream {
+		cfg.StreamEnabled = false
+	}
+	if *permissionMode != "" {
+		if _, err := permission.ParseMode(*permissionMode); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(exitUsage)
+		}
+		cfg.PermissionMode = *permissionMode
+	}
+
+	if info, err := os.Stat(cfg.WorkspaceRoot); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: workspace root %s is not a directory\n", cfg.WorkspaceRoot)
+		os.Exit(1)
+	}
 
 	// Setup logger
 	var logLevel logger.Level
@@ -41,27 +69,51 @@ func main() {
 	}
 	log := logger.New(logLevel)
 
+	sessionStore := session.NewStore(cfg.SessionDir)
+	if *listSessions {
+		if err := printSessions(sessionStore); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize OpenAI client
 	client := openai.NewClient(
 		option.WithAPIKey(cfg.OpenAIAPIKey),
 		option.WithBaseURL(cfg.OpenAIBaseURL),
+		// Retries are handled by the agent so they honor REQUEST_TIMEOUT and
+		// are not multiplied by the SDK's own retry loop.
+		option.WithMaxRetries(0),
 	)
 
 	log.Info("OpenAI client initialized")
 
 	// Initialize tool registry
 	toolRegistry := tools.NewRegistry()
-	if err := tools.RegisterBuiltinTools(toolRegistry, log); err != nil {
+	if err := tools.RegisterBuiltinTools(toolRegistry, tools.Options{Shell: cfg.Shell}, log); err != nil {
 		log.Error("Failed to register built-in tools: %v", err)
 		os.Exit(1)
 	}
 
+	oneShotInput, oneShot, err := oneShotPrompt(*prompt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(exitUsage)
+	}
+
 	// Setup user input
-	scanner := bufio.NewScanner(os.Stdin)
-	inputProvider := &ConsoleInputProvider{scanner: scanner}
+	var lines <-chan string
+	if !oneShot {
+		lines = readLines(os.Stdin)
+	}
+	inputProvider := &ConsoleInputProvider{lines: lines}
 
 	// Setup output handler
-	outputHandler := &agent.DefaultOutputHandler{}
+	var outputHandler agent.OutputHandler = &agent.DefaultOutputHandler{}
+	if oneShot {
+		outputHandler = &BatchOutputHandler{}
+	}
 
 	gocopilot := agent.NewAgent(
 		&OpenAIClientWrapper{client: &client},
@@ -71,9 +123,56 @@ func main() {
 		cfg,
 		log,
 	)
+	gocopilot.SetSessionStore(sessionStore)
+
+	rulesFile := cfg.PermissionsFile
+	if rulesFile == "" {
+		rulesFile = filepath.Join(cfg.WorkspaceRoot, permission.DefaultRulesFile)
+	}
+	// Recorded with saved sessions; resuming one keeps the current rules
+	cfg.PermissionsFile = rulesFile
+	rules, err := permission.LoadRules(rulesFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if rules != nil {
+		log.Info("Loaded %d permission rules from %s", rules.Count(), rules.Source())
+		gocopilot.Permissions().SetRules(rules)
+	}
+
+	if cfg.AuditLog != "" {
+		auditLog, err := os.OpenFile(cfg.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening audit log: %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		gocopilot.Permissions().SetAuditLog(auditLog)
+	}
+	if !oneShot {
+		gocopilot.Permissions().SetPrompter(&ConsolePrompter{lines: lines})
+	}
+
+	if *resume != "" {
+		if err := gocopilot.ResumeSession(*resume); err != nil {
+			fmt.Fprintf(os.Stderr, "Error resuming session: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if !oneShot {
+			fmt.Printf("\u001b[33m💾 Resumed session %s\u001b[0m\n", gocopilot.SessionID())
+		}
+	}
+
+	if oneShot {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		code := runOneShot(ctx, gocopilot, oneShotInput, *jsonOutput)
+		stop()
+		os.Exit(code)
+	}
 
 	fmt.Println("🤖 [1;36mGocopilot[0m - AI-powered coding assistant")
-	fmt.Println("Type your questions or commands below (use 'ctrl-c' to quit)")
+	fmt.Println("Type your questions or commands below (ctrl-c cancels a turn, press it twice or ctrl-d to quit)")
 	fmt.Println()
 
 	if *reasoning {
@@ -83,24 +182,67 @@ func main() {
 		fmt.Println()
 	}
 
+	resumeHint := func() {
+		if _, err := sessionStore.Load(gocopilot.SessionID()); err == nil {
+			fmt.Printf("Resume this session with: gocopilot -resume %s\n", gocopilot.SessionID())
+		}
+	}
+	watchInterrupts(gocopilot, resumeHint)
 
-	if err := gocopilot.Run(context.TODO()); err != nil {
+	err = gocopilot.Run(context.Background())
+	resumeHint()
+	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 		os.Exit(1)
 	}
 }
 
+func printSessions(store *session.Store) error {
+	infos, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	if len(infos) == 0 {
+		fmt.Printf("No saved sessions in %s\n", store.Dir())
+		return nil
+	}
+
+	for _, info := range infos {
+		fmt.Printf("%s  %s  %-16s %4d msgs  %s\n",
+			info.ID,
+			info.UpdatedAt.Local().Format("2006-01-02 15:04"),
+			info.Model,
+			info.Messages,
+			info.Title,
+		)
+	}
+	return nil
+}
+
 // ConsoleInputProvider implements UserInputProvider for console input
 type ConsoleInputProvider struct {
-	scanner *bufio.Scanner
+	lines <-chan string
 }
 
 func (c *ConsoleInputProvider) GetUserMessage() (string, bool) {
 	fmt.Print("\u001b[1;34m💬 You\u001b[0m: ")
-	if !c.scanner.Scan() {
-		return "", false
-	}
-	return c.scanner.Text(), true
+	line, ok := <-c.lines
+	return line, ok
+}
+
+// readLines reads stdin on its own goroutine, so that an approval prompt can
+// stop waiting for an answer when the turn is cancelled.
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
 }
 
 // OpenAIClientWrapper wraps the OpenAI client to implement InferenceClient
@@ -114,3 +256,10 @@ func (w *OpenAIClientWrapper) ChatCompletion(
 ) (*openai.ChatCompletion, error) {
 	return w.client.Chat.Completions.New(ctx, params)
 }
+
+func (w *OpenAIClientWrapper) ChatCompletionStream(
+	ctx context.Context,
+	params openai.ChatCompletionNewParams,
+) agent.ChatCompletionStream {
+	return w.client.Chat.Completions.NewStreaming(ctx, params)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gocopilot/internal/agent"
+)
+
+// Exit codes for non-interactive runs
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitMaxSteps = 3
+	exitDenied   = 4
+
+	// exitInterrupted follows the shell convention for SIGINT (128+2)
+	exitInterrupted = 130
+)
+
+// oneShotResult is printed with -json.
+type oneShotResult struct {
+	SessionID string `json:"session_id"`
+	Answer    string `json:"answer"`
+	Error     string `json:"error,omitempty"`
+	ExitCode  int    `json:"exit_code"`
+}
+
+// oneShotPrompt returns the prompt for a non-interactive run: the -p flag if
+// given, otherwise stdin when it is piped rather than a terminal.
+func oneShotPrompt(flagPrompt string) (string, bool, error) {
+	if flagPrompt != "" {
+		return flagPrompt, true, nil
+	}
+
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice != 0 {
+		return "", false, nil
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", true, fmt.Errorf("failed to read prompt from stdin: %w", err)
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// runOneShot runs a single prompt to completion and returns the exit code.
+func runOneShot(ctx context.Context, gocopilot *agent.Agent, prompt string, jsonOutput bool) int {
+	result := oneShotResult{ExitCode: exitOK}
+
+	if prompt == "" {
+		result.Error = "empty prompt"
+		result.ExitCode = exitUsage
+	} else {
+		answer, err := gocopilot.RunOnce(ctx, prompt)
+		result.Answer = answer
+		denied := gocopilot.Permissions().Unapproved()
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+			result.ExitCode = exitError
+			switch {
+			case errors.Is(err, agent.ErrMaxStepsExceeded):
+				result.ExitCode = exitMaxSteps
+			case ctx.Err() != nil:
+				result.ExitCode = exitInterrupted
+			}
+		case len(denied) > 0:
+			// There is nobody to approve calls, so the answer was produced
+			// without them and is likely incomplete.
+			result.Error = fmt.Sprintf("calls of %s were denied because they need approval, which a non-interactive run cannot ask for; "+
+				"rerun with -permissions auto or allow them in the permissions file", strings.Join(denied, ", "))
+			result.ExitCode = exitDenied
+		}
+	}
+	result.SessionID = gocopilot.SessionID()
+
+	if jsonOutput {
+		data, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(data))
+		return result.ExitCode
+	}
+
+	if result.Answer != "" {
+		fmt.Println(result.Answer)
+	}
+	if result.Error != "" {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", result.Error)
+	}
+	return result.ExitCode
+}
+
+// BatchOutputHandler keeps stdout free for the final answer in non-interactive
+// runs and reports progress on stderr instead.
+type BatchOutputHandler struct{}
+
+func (b *BatchOutputHandler) PrintAssistantMessage(content string) {}
+
+func (b *BatchOutputHandler) PrintToolCall(toolName, arguments string) {
+	fmt.Fprintf(os.Stderr, "🔧 Tool: %s(%s)\n", toolName, arguments)
+}
+
+func (b *BatchOutputHandler) PrintToolOutput(text string) {
+	fmt.Fprint(os.Stderr, text)
+}
+
+func (b *BatchOutputHandler) PrintToolResult(output string) {}
+
+func (b *BatchOutputHandler) PrintToolError(error string) {
+	fmt.Fprintf(os.Stderr, "❌ Error: %s\n", error)
+}
+
+func (b *BatchOutputHandler) PrintNotice(text string) {
+	fmt.Fprintln(os.Stderr, text)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+
+	"gocopilot/internal/agent"
+	"gocopilot/internal/config"
+	"gocopilot/internal/tools"
+)
+
+// scriptedClient answers with the given completions in order. It also
+// offers streaming so the test covers the fallback to batch output.
+type scriptedClient struct {
+	t         *testing.T
+	responses []string
+	calls     int
+}
+
+func (c *scriptedClient) ChatCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	if c.calls >= len(c.responses) {
+		return nil, errors.New("no more scripted responses")
+	}
+	var completion openai.ChatCompletion
+	if err := json.Unmarshal([]byte(c.responses[c.calls]), &completion); err != nil {
+		c.t.Fatal(err)
+	}
+	c.calls++
+	return &completion, nil
+}
+
+func (c *scriptedClient) ChatCompletionStream(ctx context.Context, params openai.ChatCompletionNewParams) agent.ChatCompletionStream {
+	c.t.Error("one-shot runs must not stream")
+	return errStream{}
+}
+
+type errStream struct{}
+
+func (errStream) Next() bool                          { return false }
+func (errStream) Current() openai.ChatCompletionChunk { return openai.ChatCompletionChunk{} }
+func (errStream) Err() error                          { return errors.New("streaming is not scripted") }
+func (errStream) Close() error                        { return nil }
+
+const editCallResponse = `{"id":"1","object":"chat.completion","model":"test","choices":[{"index":0,"finish_reason":"tool_calls",
+"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function",
+"function":{"name":"edit_file","arguments":"{\"path\":\"out.txt\",\"old_str\":\"\",\"new_str\":\"<hi & bye>\"}"}}]}}]}`
+
+const finalResponse = `{"id":"2","object":"chat.completion","model":"test","choices":[{"index":0,"finish_reason":"stop",
+"message":{"role":"assistant","content":"Final answer: wrote out.txt"}}]}`
+
+// runOneShotJSON runs a scripted one-shot with -json in workspace and returns
+// its stdout, stderr and exit code.
+func runOneShotJSON(t *testing.T, workspace, permissionMode string) (string, string, int) {
+	t.Helper()
+
+	registry := tools.NewRegistry()
+	if err := tools.RegisterBuiltinTools(registry, tools.Options{}, &agent.NoopLogger{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Model:             "test",
+		MaxTokens:         256,
+		MemoryCapacity:    20,
+		MaxConcurrency:    1,
+		ReasoningEnabled:  true,
+		ReasoningMaxSteps: 5,
+		StreamEnabled:     true,
+		WorkspaceRoot:     workspace,
+		PermissionMode:    permissionMode,
+	}
+	client := &scriptedClient{t: t, responses: []string{editCallResponse, finalResponse}}
+	gocopilot := agent.NewAgent(client, &ConsoleInputProvider{}, &BatchOutputHandler{}, registry, cfg, nil)
+
+	stdout, stderr := os.Stdout, os.Stderr
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = outW, errW
+	outC, errC := make(chan string), make(chan string)
+	go func() { data, _ := io.ReadAll(outR); outC <- string(data) }()
+	go func() { data, _ := io.ReadAll(errR); errC <- string(data) }()
+
+	code := runOneShot(context.Background(), gocopilot, "write out.txt", true)
+
+	os.Stdout, os.Stderr = stdout, stderr
+	outW.Close()
+	errW.Close()
+	return <-outC, <-errC, code
+}
+
+func TestOneShotJSONWritesOnlyJSONToStdout(t *testing.T) {
+	workspace := t.TempDir()
+	out, stderr, code := runOneShotJSON(t, workspace, "auto")
+
+	var result oneShotResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("stdout is not a single JSON document: %v\n%s", err, out)
+	}
+	if code != exitOK || result.ExitCode != exitOK {
+		t.Fatalf("exit code = %d, result = %+v", code, result)
+	}
+	if result.Answer != "Final answer: wrote out.txt" {
+		t.Errorf("answer = %q", result.Answer)
+	}
+	if data, err := os.ReadFile(filepath.Join(workspace, "out.txt")); err != nil || string(data) != "<hi & bye>" {
+		t.Errorf("out.txt = %q, %v", data, err)
+	}
+	if !strings.Contains(stderr, "🧠 Step 1") {
+		t.Errorf("expected reasoning progress on stderr, got:\n%s", stderr)
+	}
+}
+
+func TestOneShotReportsCallsThatNeedApproval(t *testing.T) {
+	workspace := t.TempDir()
+	out, _, code := runOneShotJSON(t, workspace, "ask-mutating")
+
+	var result oneShotResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("stdout is not a single JSON document: %v\n%s", err, out)
+	}
+	if code != exitDenied || result.ExitCode != exitDenied {
+		t.Fatalf("exit code = %d, result = %+v", code, result)
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "out.txt")); !os.IsNotExist(err) {
+		t.Errorf("out.txt was written without approval")
+	}
+	if !strings.Contains(result.Error, "edit_file") || !strings.Contains(result.Error, "-permissions auto") {
+		t.Errorf("error = %q", result.Error)
+	}
+}
@@ -5,6 +5,7 @@ go 1.23.4
 require (
 	github.com/invopop/jsonschema v0.13.0
 	github.com/openai/openai-go/v3 v3.0.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -12,7 +13,6 @@ require (
 	github.com/buger/jsonparser v1.1.1 // indirect
 	github.com/mailru/easyjson v0.9.1 // indirect
 	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
@@ -2,12 +2,17 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/openai/openai-go/v3"
 
 	"gocopilot/internal/config"
+	"gocopilot/internal/instructions"
+	"gocopilot/internal/permission"
+	"gocopilot/internal/session"
 	"gocopilot/internal/tools"
 )
 
@@ -19,7 +24,25 @@ type Agent struct {
 	executor    *ToolExecutor
 	logger      Logger
 	config      *config.Config
+	registry    *tools.Registry
 	toolConfigs []openai.ChatCompletionToolUnionParam
+	commands    *CommandRegistry
+	permissions *permission.Gate
+	journal     *tools.Journal
+	promptFiles []instructions.File
+	sessions    *session.Store
+	session     *session.Session
+	tokenizer   Tokenizer
+	retry       RetryPolicy
+	sleep       func(ctx context.Context, d time.Duration) error
+	jitter      func() float64
+
+	turnMu     sync.Mutex
+	cancelTurn context.CancelFunc
+
+	// saveMu serializes session saves, which may also come from the
+	// interrupt handler
+	saveMu sync.Mutex
 }
 
 func NewAgent(
@@ -39,10 +62,40 @@ func NewAgent(
 	}
 
 	memory := NewMemory(cfg.MemoryCapacity)
+	memory.SetCompaction(cfg.CompactionEnabled)
 	executor := NewToolExecutor(registry, cfg.MaxConcurrency, logger)
+	executor.SetWorkspace(tools.NewWorkspace(cfg.WorkspaceRoot, cfg.ReadOnlyRoots...))
+	setToolTimeouts(executor, cfg)
+	if handler, ok := output.(ToolOutputHandler); ok {
+		executor.SetOutput(&toolOutputWriter{handler: handler})
+	}
+
+	mode, err := permission.ParseMode(cfg.PermissionMode)
+	if err != nil {
+		logger.Error("Invalid PERMISSION_MODE, asking before every tool call: %v", err)
+		mode = permission.ModeAsk
+	}
+	permissions := permission.NewGate(mode, logger)
+	executor.SetPermissions(permissions)
+	journal := tools.NewJournal()
+	executor.SetJournal(journal)
 	toolConfigs := registry.ToolConfigs()
 
-	return &Agent{
+	commands := NewCommandRegistry()
+	if err := RegisterBuiltinCommands(commands); err != nil {
+		logger.Error("Failed to register built-in commands: %v", err)
+	}
+
+	retry := DefaultRetryPolicy()
+	retry.MaxAttempts = cfg.MaxRetries + 1
+	if cfg.RetryBaseDelayMs > 0 {
+		retry.BaseDelay = time.Duration(cfg.RetryBaseDelayMs) * time.Millisecond
+	}
+	if cfg.RetryMaxDelayMs > 0 {
+		retry.MaxDelay = time.Duration(cfg.RetryMaxDelayMs) * time.Millisecond
+	}
+
+	a := &Agent{
 		client:      client,
 		input:       input,
 		output:      output,
@@ -50,18 +103,79 @@ func NewAgent(
 		executor:    executor,
 		logger:      logger,
 		config:      cfg,
+		registry:    registry,
 		toolConfigs: toolConfigs,
+		commands:    commands,
+		permissions: permissions,
+		journal:     journal,
+		tokenizer:   HeuristicTokenizer{},
+		retry:       retry,
+		sleep:       sleepContext,
+		jitter:      defaultJitter,
+	}
+	a.updateContextBudget()
+
+	return a
+}
+
+// setToolTimeouts applies TOOL_TIMEOUT and TOOL_TIMEOUTS to the executor.
+func setToolTimeouts(executor *ToolExecutor, cfg *config.Config) {
+	toolTimeouts := make(map[string]time.Duration, len(cfg.ToolTimeouts))
+	for name, seconds := range cfg.ToolTimeouts {
+		toolTimeouts[name] = time.Duration(seconds) * time.Second
+	}
+	executor.SetTimeouts(time.Duration(cfg.ToolTimeout)*time.Second, toolTimeouts)
+}
+
+// Permissions returns the gate that approves tool calls, e.g. to install a
+// prompter for interactive approval.
+func (a *Agent) Permissions() *permission.Gate {
+	return a.permissions
+}
+
+// Journal returns the record of file changes made by tools in this session.
+func (a *Agent) Journal() *tools.Journal {
+	return a.journal
+}
+
+// SetTokenizer replaces the tokenizer used to budget the conversation context.
+func (a *Agent) SetTokenizer(tokenizer Tokenizer) {
+	if tokenizer == nil {
+		tokenizer = HeuristicTokenizer{}
+	}
+
+	a.tokenizer = tokenizer
+	a.memory.SetTokenizer(tokenizer)
+	a.updateContextBudget()
+}
+
+// updateContextBudget sizes the memory token budget for the current model,
+// reserving room for the response and the tool schemas sent with every request.
+func (a *Agent) updateContextBudget() {
+	window := a.config.ContextWindow
+	if window <= 0 {
+		window = ContextWindowForModel(a.config.Model, a.config.ModelContextWindows)
+	}
+
+	reserved := a.config.MaxTokens + countToolTokens(a.tokenizer, a.toolConfigs)
+	budget := window - reserved
+	if budget <= 0 {
+		a.logger.Warn("Context window %d of model %s leaves no room after reserving %d tokens", window, a.config.Model, reserved)
+		budget = 1
 	}
+
+	a.logger.Debug("Context budget for model %s: %d of %d tokens", a.config.Model, budget, window)
+	a.memory.SetTokenBudget(budget)
 }
 
 func (a *Agent) Run(ctx context.Context) error {
     a.logger.Info("Starting chat session")
-    a.memory.ResetHistory()
 
-	// Set system message if provided
-	if systemMsg := os.Getenv("SYSTEM_MESSAGE"); systemMsg != "" {
-		a.memory.SetSystemMessages(openai.SystemMessage(systemMsg))
+	// Continue a resumed session, otherwise start from a clean slate
+	if a.session == nil {
+		a.NewSession()
 	}
+	defer a.autosave()
 
 	for {
 		userInput, ok := a.input.GetUserMessage()
@@ -75,27 +189,22 @@ func (a *Agent) Run(ctx context.Context) error {
 			continue
 		}
 
+		if a.isCommand(userInput) {
+			a.handleCommand(ctx, userInput)
+			continue
+		}
+
         a.logger.Debug("User input received: %q", userInput)
 
-        // If reasoning mode is enabled, use the ReasoningChain to handle this turn.
-        if a.config.ReasoningEnabled {
-            chain := NewReasoningChain(a.config.ReasoningMaxSteps, a.logger)
-            if _, err := chain.Execute(ctx, a, userInput); err != nil {
-                a.logger.Error("Error during reasoning execution: %v", err)
-                return err
-            }
-        } else {
-            userMessage := openai.UserMessage(userInput)
-            a.memory.Append(userMessage)
-
-            a.logger.Debug("Sending message to Gocopilot, conversation length: %d", a.memory.MessageCount())
-
-            if err := a.processConversation(ctx); err != nil {
-                a.logger.Error("Error during conversation processing: %v", err)
-                return err
-            }
+		if _, err := a.runTurn(ctx, userInput); err != nil {
+			if !errors.Is(err, ErrTurnCancelled) {
+				return err
+			}
+			a.notice("⏹ Turn cancelled")
         }
 
+		a.autosave()
+
 		fmt.Println() // Add empty line between interactions
 	}
 
@@ -103,18 +212,103 @@ func (a *Agent) Run(ctx context.Context) error {
 	return nil
 }
 
-func (a *Agent) processConversation(ctx context.Context) error {
-	for {
-		response, err := a.runInference(ctx, a.memory.Context())
+// RunOnce runs a single prompt to completion, including all tool rounds, and
+// returns the final assistant answer. It is used for non-interactive runs.
+func (a *Agent) RunOnce(ctx context.Context, prompt string) (string, error) {
+	if a.session == nil {
+		a.NewSession()
+	}
+	defer a.autosave()
+
+	return a.runTurn(ctx, prompt)
+}
+
+// CancelTurn stops the turn or command in progress, including running
+// inference and tool calls, and reports whether there was one to cancel.
+func (a *Agent) CancelTurn() bool {
+	a.turnMu.Lock()
+	defer a.turnMu.Unlock()
+
+	if a.cancelTurn == nil {
+		return false
+	}
+	a.cancelTurn()
+	a.cancelTurn = nil
+	return true
+}
+
+// runTurn handles one user message and returns the final assistant answer.
+// A turn stopped by CancelTurn returns ErrTurnCancelled and leaves the history
+// ready for the next message.
+func (a *Agent) runTurn(ctx context.Context, userInput string) (string, error) {
+	turnCtx, done := a.cancellable(ctx)
+	defer done()
+	a.journal.BeginTurn()
+	a.refreshRepoMap(turnCtx)
+
+	answer, err := a.executeTurn(turnCtx, userInput)
+	if turnCtx.Err() != nil {
+		if added := a.memory.CloseDanglingToolCalls("Error: tool execution cancelled by the user"); added > 0 {
+			a.logger.Debug("Closed %d unanswered tool calls after cancellation", added)
+		}
+		if ctx.Err() == nil {
+			return answer, ErrTurnCancelled
+		}
+	}
+	return answer, err
+}
+
+// cancellable returns a context that CancelTurn cancels until done is called.
+// Turns and commands run under it so Ctrl-C can stop them.
+func (a *Agent) cancellable(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	a.turnMu.Lock()
+	a.cancelTurn = cancel
+	a.turnMu.Unlock()
+
+	return ctx, func() {
+		a.turnMu.Lock()
+		a.cancelTurn = nil
+		a.turnMu.Unlock()
+		cancel()
+	}
+}
+
+func (a *Agent) executeTurn(ctx context.Context, userInput string) (string, error) {
+	// If reasoning mode is enabled, use the ReasoningChain to handle this turn.
+	if a.config.ReasoningEnabled {
+		chain := NewReasoningChain(a.config.ReasoningMaxSteps, a.logger)
+		answer, err := chain.Execute(ctx, a, userInput)
+		if err != nil {
+			a.logger.Error("Error during reasoning execution: %v", err)
+		}
+		return answer, err
+	}
+
+	userMessage := openai.UserMessage(userInput)
+	a.memory.Append(userMessage)
+
+	a.logger.Debug("Sending message to Gocopilot, conversation length: %d", a.memory.MessageCount())
+
+	answer, err := a.processConversation(ctx)
+	if err != nil {
+		a.logger.Error("Error during conversation processing: %v", err)
+	}
+	return answer, err
+}
+
+func (a *Agent) processConversation(ctx context.Context) (string, error) {
+	for round := 0; a.config.MaxToolRounds <= 0 || round < a.config.MaxToolRounds; round++ {
+		response, err := a.runInference(ctx)
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		message := response.Choices[0].Message
 		a.memory.Append(message.ToParam())
 
-		// Handle assistant message
-		if message.Content != "" {
+		// Handle assistant message; streamed content has already been printed
+		if message.Content != "" && !a.streamingEnabled() {
 			a.output.PrintAssistantMessage(message.Content)
 		}
 
@@ -133,7 +327,7 @@ func (a *Agent) processConversation(ctx context.Context) error {
 			// Execute tool calls
 			toolMessages, err := a.executor.ExecuteToolCalls(ctx, message.ToolCalls)
 			if err != nil {
-				return err
+				return "", err
 			}
 
 			// Print tool results and add to memory
@@ -143,40 +337,173 @@ func (a *Agent) processConversation(ctx context.Context) error {
 				a.memory.Append(toolMsg)
 			}
 
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+
 			// Continue processing with tool results
 			continue
 		}
 
 		// No tool calls, conversation complete
-		break
+		return message.Content, nil
 	}
 
-	return nil
+	a.logger.Warn("Conversation reached maximum tool rounds (%d) without completion", a.config.MaxToolRounds)
+	return "", fmt.Errorf("%w: no final answer after %d tool rounds", ErrMaxStepsExceeded, a.config.MaxToolRounds)
+}
+
+// notice prints a status line through the output handler if it accepts them.
+func (a *Agent) notice(format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	if handler, ok := a.output.(NoticeOutputHandler); ok {
+		handler.PrintNotice(text)
+		return
+	}
+	fmt.Println(text)
 }
 
+// runInference sends the current conversation to the model, compacting
+// history first if it has outgrown the context budget.
+func (a *Agent) runInference(ctx context.Context) (*openai.ChatCompletion, error) {
+	if a.config.CompactionEnabled {
+		a.compactIfNeeded(ctx)
+	}
 
-func (a *Agent) runInference(ctx context.Context, conversation []openai.ChatCompletionMessageParamUnion) (*openai.ChatCompletion, error) {
 	params := openai.ChatCompletionNewParams{
 		Model:     a.config.Model,
 		MaxTokens: openai.Int(int64(a.config.MaxTokens)),
-		Messages:  conversation,
+		Messages:  a.memory.Context(),
 	}
 
 	if len(a.toolConfigs) > 0 {
 		params.Tools = a.toolConfigs
 	}
 
-	response, err := a.client.ChatCompletion(ctx, params)
+	return a.requestWithRetry(ctx, params, a.streamingEnabled())
+}
 
-	if err != nil {
-		a.logger.Error("API call failed: %v", err)
-	} else {
-		a.logger.Debug("API call successful, response received")
+// requestWithRetry performs an inference request, retrying transient failures.
+func (a *Agent) requestWithRetry(ctx context.Context, params openai.ChatCompletionNewParams, stream bool) (*openai.ChatCompletion, error) {
+	attempts := a.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, emitted, err := a.inferOnce(ctx, params, stream)
+		if err == nil {
+			a.logger.Debug("API call successful, response received")
+			return response, nil
+		}
+
+		// A partially streamed answer has already reached the user, so
+		// replaying the request would print it twice.
+		if attempt >= attempts || emitted || !IsRetryableError(err) {
+			a.logger.Error("API call failed: %v", err)
+			return nil, err
+		}
+
+		delay := a.retry.Backoff(attempt, a.jitter)
+		if hint, ok := retryAfter(err); ok && hint > delay {
+			delay = hint
+		}
+
+		a.logger.Warn("API call failed (attempt %d/%d): %v, retrying in %s", attempt, attempts, err, delay)
+		if err := a.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// inferOnce performs a single inference request bounded by REQUEST_TIMEOUT.
+// It reports whether any streamed content was emitted before returning.
+func (a *Agent) inferOnce(ctx context.Context, params openai.ChatCompletionNewParams, stream bool) (*openai.ChatCompletion, bool, error) {
+	timeout := time.Duration(a.config.RequestTimeout) * time.Second
+
+	if stream {
+		return a.streamInference(ctx, params, timeout)
+	}
+
+	reqCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
 	}
+	defer cancel()
 
-	return response, err
+	response, err := a.client.ChatCompletion(reqCtx, params)
+	if err != nil && ctx.Err() == nil && reqCtx.Err() != nil {
+		err = fmt.Errorf("%w after %s: %v", errRequestTimeout, timeout, err)
+	}
+	return response, false, err
+}
+
+// streamingEnabled reports whether responses are streamed for this agent.
+// Streaming needs both a client and an output handler that support it.
+func (a *Agent) streamingEnabled() bool {
+	if !a.config.StreamEnabled {
+		return false
+	}
+	if _, ok := a.client.(StreamingInferenceClient); !ok {
+		return false
+	}
+	_, ok := a.output.(StreamingOutputHandler)
+	return ok
 }
 
+// streamInference consumes a streamed completion. The timeout applies to the
+// gap between chunks rather than the whole response, so long answers are not
+// cut off as long as the server keeps sending data.
+func (a *Agent) streamInference(ctx context.Context, params openai.ChatCompletionNewParams, timeout time.Duration) (*openai.ChatCompletion, bool, error) {
+	client := a.client.(StreamingInferenceClient)
+	output := a.output.(StreamingOutputHandler)
+
+	reqCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	resetIdle := func() {}
+	if timeout > 0 {
+		idle := time.AfterFunc(timeout, func() { cancel(errRequestTimeout) })
+		defer idle.Stop()
+		resetIdle = func() { idle.Reset(timeout) }
+	}
+
+	stream := client.ChatCompletionStream(reqCtx, params)
+	defer stream.Close()
+
+	acc := newStreamAccumulator()
+	chunks := 0
+	emitted := false
+	for stream.Next() {
+		resetIdle()
+
+		chunk := stream.Current()
+		if err := acc.AddChunk(chunk); err != nil {
+			output.EndAssistantMessage()
+			return nil, emitted, err
+		}
+		chunks++
+
+		for _, choice := range chunk.Choices {
+			if choice.Index == 0 && choice.Delta.Content != "" {
+				output.PrintAssistantDelta(choice.Delta.Content)
+				emitted = true
+			}
+		}
+	}
+	output.EndAssistantMessage()
+
+	if err := stream.Err(); err != nil {
+		if ctx.Err() == nil && context.Cause(reqCtx) == errRequestTimeout {
+			err = fmt.Errorf("%w: no data for %s: %v", errRequestTimeout, timeout, err)
+		}
+		return nil, emitted, err
+	}
+
+	a.logger.Debug("Stream finished after %d chunks", chunks)
+	response, err := acc.Completion()
+	return response, emitted, err
+}
 
 type NoopLogger struct{}
 
@@ -0,0 +1,435 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go/v3"
+
+	"gocopilot/internal/permission"
+	"gocopilot/internal/tools"
+)
+
+// maxHistoryPreviewRunes bounds each message printed by /history.
+const maxHistoryPreviewRunes = 200
+
+func RegisterBuiltinCommands(registry *CommandRegistry) error {
+	commands := []Command{
+		{
+			Name:        "help",
+			Usage:       "/help",
+			Description: "List available commands",
+			Handler:     helpCommand,
+		},
+		{
+			Name:        "reset",
+			Usage:       "/reset",
+			Description: "Clear the conversation and start a new session",
+			Handler:     resetCommand,
+		},
+		{
+			Name:        "model",
+			Usage:       "/model [name]",
+			Description: "Show or switch the model",
+			Handler:     modelCommand,
+		},
+		{
+			Name:        "tools",
+			Usage:       "/tools",
+			Description: "List the tools available to the model",
+			Handler:     toolsCommand,
+		},
+		{
+			Name:        "history",
+			Usage:       "/history",
+			Description: "Show the messages in the current context",
+			Handler:     historyCommand,
+		},
+		{
+			Name:        "save",
+			Usage:       "/save",
+			Description: "Save the current session",
+			Handler:     saveCommand,
+		},
+		{
+			Name:        "load",
+			Usage:       "/load <id|last>",
+			Description: "Resume a saved session",
+			Handler:     loadCommand,
+		},
+		{
+			Name:        "sessions",
+			Usage:       "/sessions",
+			Description: "List saved sessions",
+			Handler:     sessionsCommand,
+		},
+		{
+			Name:        "reasoning",
+			Usage:       "/reasoning on|off",
+			Description: "Toggle multi-step reasoning mode",
+			Handler:     reasoningCommand,
+		},
+		{
+			Name:        "system",
+			Usage:       "/system [text]",
+			Description: "Show or replace the system message",
+			Handler:     systemCommand,
+			RawArgs:     true,
+		},
+		{
+			Name:        "prompt",
+			Usage:       "/prompt [reload]",
+			Description: "Show the effective system prompt and its sources",
+			Handler:     promptCommand,
+		},
+		{
+			Name:        "permissions",
+			Usage:       "/permissions [mode]",
+			Description: "Show or switch the tool approval mode",
+			Handler:     permissionsCommand,
+		},
+		{
+			Name:        "changes",
+			Usage:       "/changes",
+			Description: "List the file changes made by tools in this session",
+			Handler:     changesCommand,
+		},
+		{
+			Name:        "diff",
+			Usage:       "/diff [id]",
+			Description: "Show the diff of a file change, or of all changes",
+			Handler:     diffCommand,
+		},
+		{
+			Name:        "undo",
+			Usage:       "/undo [n] | /undo turn <n>",
+			Description: "Revert the last n file changes or all changes of a turn",
+			Handler:     undoCommand,
+		},
+		{
+			Name:        "compact",
+			Usage:       "/compact",
+			Description: "Summarize older history to free up context",
+			Handler:     compactCommand,
+		},
+	}
+
+	for _, cmd := range commands {
+		if err := registry.Register(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func helpCommand(ctx context.Context, a *Agent, args []string) error {
+	for _, cmd := range a.commands.List() {
+		a.notice("  %-22s %s", cmd.Usage, cmd.Description)
+	}
+	return nil
+}
+
+func resetCommand(ctx context.Context, a *Agent, args []string) error {
+	a.autosave()
+	a.NewSession()
+	a.notice("🧹 Conversation cleared, new session %s", a.SessionID())
+	return nil
+}
+
+func modelCommand(ctx context.Context, a *Agent, args []string) error {
+	if len(args) == 0 {
+		a.notice("Model: %s", a.config.Model)
+		return nil
+	}
+
+	a.config.Model = args[0]
+	a.updateContextBudget()
+	a.notice("Switched model to %s", a.config.Model)
+	return nil
+}
+
+func toolsCommand(ctx context.Context, a *Agent, args []string) error {
+	for _, name := range a.toolNames() {
+		tool, _ := a.registry.Get(name)
+		description, _, _ := strings.Cut(strings.TrimSpace(tool.Description), "\n")
+		a.notice("  %-14s %s", tool.Name, description)
+	}
+	return nil
+}
+
+func historyCommand(ctx context.Context, a *Agent, args []string) error {
+	system, summary, history := a.memory.Snapshot()
+	if len(system) == 0 && summary == "" && len(history) == 0 {
+		a.notice("History is empty")
+		return nil
+	}
+
+	for _, message := range system {
+		a.notice("%s", formatHistoryMessage(message))
+	}
+	if summary != "" {
+		a.notice("[summary] %s", previewText(summary))
+	}
+	for _, message := range history {
+		a.notice("%s", formatHistoryMessage(message))
+	}
+	a.notice("%d messages, ~%d tokens", a.memory.MessageCount(), a.memory.TokenCount())
+	return nil
+}
+
+func saveCommand(ctx context.Context, a *Agent, args []string) error {
+	if err := a.SaveSession(); err != nil {
+		return err
+	}
+	a.notice("💾 Saved session %s", a.SessionID())
+	return nil
+}
+
+func loadCommand(ctx context.Context, a *Agent, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: /load <id|last>")
+	}
+
+	a.autosave()
+	if err := a.ResumeSession(args[0]); err != nil {
+		return err
+	}
+	a.notice("💾 Resumed session %s", a.SessionID())
+	return nil
+}
+
+func sessionsCommand(ctx context.Context, a *Agent, args []string) error {
+	if a.sessions == nil {
+		return fmt.Errorf("session storage is not configured")
+	}
+
+	infos, err := a.sessions.List()
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		a.notice("No saved sessions")
+		return nil
+	}
+
+	for _, info := range infos {
+		a.notice("  %s  %s  %4d msgs  %s", info.ID, info.UpdatedAt.Local().Format("2006-01-02 15:04"), info.Messages, info.Title)
+	}
+	return nil
+}
+
+func reasoningCommand(ctx context.Context, a *Agent, args []string) error {
+	if len(args) == 0 {
+		a.notice("Reasoning mode: %s", onOff(a.config.ReasoningEnabled))
+		return nil
+	}
+
+	switch args[0] {
+	case "on":
+		a.config.ReasoningEnabled = true
+	case "off":
+		a.config.ReasoningEnabled = false
+	default:
+		return fmt.Errorf("usage: /reasoning on|off")
+	}
+
+	a.notice("Reasoning mode: %s", onOff(a.config.ReasoningEnabled))
+	return nil
+}
+
+func systemCommand(ctx context.Context, a *Agent, args []string) error {
+	if len(args) == 0 {
+		system, _, _ := a.memory.Snapshot()
+		if len(system) == 0 {
+			a.notice("No system message set")
+		}
+		for _, message := range system {
+			a.notice("%s", describeMessage(message).Text())
+		}
+		return nil
+	}
+
+	a.memory.SetSystemMessages(openai.SystemMessage(args[0]))
+	a.refreshRepoMap(ctx)
+	a.notice("System message updated")
+	return nil
+}
+
+func promptCommand(ctx context.Context, a *Agent, args []string) error {
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "reload":
+		a.memory.SetSystemMessages()
+		if systemMsg := a.systemPrompt(); systemMsg != "" {
+			a.memory.SetSystemMessages(openai.SystemMessage(systemMsg))
+		}
+		a.refreshRepoMap(ctx)
+		a.notice("System prompt reloaded")
+	default:
+		return fmt.Errorf("usage: /prompt [reload]")
+	}
+
+	if len(a.promptFiles) == 0 {
+		a.notice("Instruction files: none")
+	} else {
+		a.notice("Instruction files (later ones take precedence):")
+		for _, file := range a.promptFiles {
+			truncated := ""
+			if file.Truncated {
+				truncated = " (truncated)"
+			}
+			a.notice("  %s%s", file.Path, truncated)
+		}
+	}
+	if a.config.SystemMessage != "" {
+		a.notice("SYSTEM_MESSAGE: set")
+	}
+
+	system, _, _ := a.memory.Snapshot()
+	if len(system) == 0 {
+		a.notice("No system prompt")
+		return nil
+	}
+	tokens := 0
+	for i, message := range system {
+		text := describeMessage(message).Text()
+		tokens += a.tokenizer.CountTokens(text)
+		a.notice("--- system message %d of %d ---\n%s", i+1, len(system), text)
+	}
+	a.notice("~%d tokens", tokens)
+	return nil
+}
+
+func permissionsCommand(ctx context.Context, a *Agent, args []string) error {
+	if len(args) == 0 {
+		a.notice("Permission mode: %s", a.permissions.Mode())
+		return nil
+	}
+
+	mode, err := permission.ParseMode(args[0])
+	if err != nil {
+		return err
+	}
+	a.permissions.SetMode(mode)
+	a.notice("Permission mode: %s", mode)
+	return nil
+}
+
+func compactCommand(ctx context.Context, a *Agent, args []string) error {
+	count, err := a.Compact(ctx)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		a.notice("Nothing to compact")
+	}
+	return nil
+}
+
+func changesCommand(ctx context.Context, a *Agent, args []string) error {
+	changes := a.journal.Changes()
+	if len(changes) == 0 {
+		a.notice("No file changes in this session")
+		return nil
+	}
+
+	for _, change := range changes {
+		added, removed := change.Stat()
+		a.notice("  #%-3d turn %-3d %s %-40s +%d -%d  %s", change.ID, change.Turn, change.Kind(), change.Name, added, removed, change.CallID)
+	}
+	return nil
+}
+
+func diffCommand(ctx context.Context, a *Agent, args []string) error {
+	if len(args) == 0 {
+		changes := a.journal.Changes()
+		if len(changes) == 0 {
+			a.notice("No file changes in this session")
+		}
+		for _, change := range changes {
+			a.notice("# change #%d (turn %d)\n%s", change.ID, change.Turn, change.Diff())
+		}
+		return nil
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil {
+		return fmt.Errorf("usage: /diff [id]")
+	}
+	change, ok := a.journal.Get(id)
+	if !ok {
+		return fmt.Errorf("no change #%d", id)
+	}
+	a.notice("%s", change.Diff())
+	return nil
+}
+
+func undoCommand(ctx context.Context, a *Agent, args []string) error {
+	var undone []tools.Change
+	var err error
+	switch {
+	case len(args) == 0:
+		undone, err = a.journal.UndoLast(1)
+	case len(args) == 2 && args[0] == "turn":
+		turn, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("usage: /undo [n] | /undo turn <n>")
+		}
+		undone, err = a.journal.UndoTurn(turn)
+	case len(args) == 1:
+		n, convErr := strconv.Atoi(args[0])
+		if convErr != nil || n <= 0 {
+			return fmt.Errorf("usage: /undo [n] | /undo turn <n>")
+		}
+		undone, err = a.journal.UndoLast(n)
+	default:
+		return fmt.Errorf("usage: /undo [n] | /undo turn <n>")
+	}
+
+	for _, change := range undone {
+		a.notice("↩ Reverted #%d %s %s", change.ID, change.Kind(), change.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(undone) == 0 {
+		a.notice("Nothing to undo")
+	}
+	return nil
+}
+
+func formatHistoryMessage(message openai.ChatCompletionMessageParamUnion) string {
+	info := describeMessage(message)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]", info.Role)
+	if info.ToolCallID != "" {
+		fmt.Fprintf(&b, " (%s)", info.ToolCallID)
+	}
+	if text := info.Text(); text != "" {
+		b.WriteString(" ")
+		b.WriteString(previewText(text))
+	}
+	for _, call := range info.ToolCalls {
+		fmt.Fprintf(&b, " → %s(%s)", call.Function.Name, previewText(call.Function.Arguments))
+	}
+	return b.String()
+}
+
+func previewText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= maxHistoryPreviewRunes {
+		return text
+	}
+	return string([]rune(text)[:maxHistoryPreviewRunes]) + "…"
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// CommandPrefix marks a REPL line as a command instead of a prompt.
+const CommandPrefix = "/"
+
+// Command is a REPL command invoked as "/name args...".
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Handler     func(ctx context.Context, a *Agent, args []string) error
+	// RawArgs passes the rest of the line as a single argument with its
+	// whitespace intact instead of splitting it into fields.
+	RawArgs bool
+}
+
+type CommandRegistry struct {
+	commands map[string]Command
+	mu       sync.RWMutex
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		commands: make(map[string]Command),
+	}
+}
+
+func (r *CommandRegistry) Register(cmd Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.commands[cmd.Name]; exists {
+		return fmt.Errorf("command '%s' already registered", cmd.Name)
+	}
+
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+func (r *CommandRegistry) Get(name string) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmd, exists := r.commands[name]
+	return cmd, exists
+}
+
+// List returns all commands sorted by name.
+func (r *CommandRegistry) List() []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	commands := make([]Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// Commands returns the agent's command registry so callers can add their own.
+func (a *Agent) Commands() *CommandRegistry {
+	return a.commands
+}
+
+// isCommand reports whether a REPL line should be dispatched as a command.
+// Only registered command names count, so a prompt that starts with a path
+// such as "/etc/hosts" still goes to the model.
+func (a *Agent) isCommand(input string) bool {
+	if !strings.HasPrefix(input, CommandPrefix) {
+		return false
+	}
+	name, _ := splitCommand(input)
+	_, ok := a.commands.Get(name)
+	return ok
+}
+
+// splitCommand splits a command line into the command name and the rest of
+// the line.
+func splitCommand(input string) (name, rest string) {
+	line := strings.TrimPrefix(input, CommandPrefix)
+	name = line
+	if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+		name, rest = line[:i], strings.TrimSpace(line[i:])
+	}
+	return name, rest
+}
+
+// handleCommand runs a command line. Command failures are reported to the
+// user and never end the chat session.
+func (a *Agent) handleCommand(ctx context.Context, input string) {
+	name, rest := splitCommand(input)
+	if name == "" {
+		return
+	}
+
+	cmd, ok := a.commands.Get(name)
+	if !ok {
+		a.notice("\u001b[31m❌ Unknown command /%s, type /help for a list of commands\u001b[0m", name)
+		return
+	}
+
+	args := strings.Fields(rest)
+	if cmd.RawArgs {
+		args = nil
+		if rest != "" {
+			args = []string{rest}
+		}
+	}
+
+	a.logger.Debug("Running command /%s with args %v", name, args)
+	cmdCtx, done := a.cancellable(ctx)
+	defer done()
+	if err := cmd.Handler(cmdCtx, a, args); err != nil {
+		if cmdCtx.Err() != nil && ctx.Err() == nil {
+			a.notice("⏹ /%s cancelled", name)
+			return
+		}
+		a.notice("\u001b[31m❌ /%s: %s\u001b[0m", name, err.Error())
+	}
+}
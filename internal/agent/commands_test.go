@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gocopilot/internal/config"
+	"gocopilot/internal/tools"
+)
+
+type discardNotices struct{ DefaultOutputHandler }
+
+func (discardNotices) PrintNotice(text string) {}
+
+func TestSystemCommandKeepsWhitespace(t *testing.T) {
+	cfg := &config.Config{MemoryCapacity: 10, MaxConcurrency: 1, WorkspaceRoot: t.TempDir()}
+	a := NewAgent(&scriptedClient{}, nil, &discardNotices{}, tools.NewRegistry(), cfg, nil)
+
+	prompt := "You review Go code.\n\n  - keep  diffs small\n\t- no new deps"
+	a.handleCommand(context.Background(), "/system "+prompt)
+
+	system, _, _ := a.memory.Snapshot()
+	if len(system) != 1 {
+		t.Fatalf("got %d system messages, want 1", len(system))
+	}
+	if got := describeMessage(system[0]).Text(); got != prompt {
+		t.Errorf("system message = %q, want %q", got, prompt)
+	}
+}
+
+func TestCancelTurnStopsCommand(t *testing.T) {
+	cfg := &config.Config{MemoryCapacity: 10, MaxConcurrency: 1, WorkspaceRoot: t.TempDir()}
+	a := NewAgent(&scriptedClient{}, nil, &discardNotices{}, tools.NewRegistry(), cfg, nil)
+
+	started := make(chan struct{})
+	err := a.Commands().Register(Command{
+		Name: "wait",
+		Handler: func(ctx context.Context, a *Agent, args []string) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		a.handleCommand(context.Background(), "/wait")
+		close(finished)
+	}()
+
+	<-started
+	if !a.CancelTurn() {
+		t.Fatal("CancelTurn found nothing to cancel while a command was running")
+	}
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command was not cancelled")
+	}
+	if a.CancelTurn() {
+		t.Error("CancelTurn still has something to cancel after the command finished")
+	}
+}
+
+func TestIsCommandOnlyMatchesRegisteredNames(t *testing.T) {
+	cfg := &config.Config{MemoryCapacity: 10, MaxConcurrency: 1, WorkspaceRoot: t.TempDir()}
+	a := NewAgent(&scriptedClient{}, nil, &discardNotices{}, tools.NewRegistry(), cfg, nil)
+
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"/help", true},
+		{"/system  be brief", true},
+		{"/etc/hosts has a bad entry, fix it", false},
+		{"/usr/local/bin is missing from PATH", false},
+		{"/nosuchcommand", false},
+		{"/", false},
+		{"help", false},
+	}
+	for _, tt := range tests {
+		if got := a.isCommand(tt.input); got != tt.want {
+			t.Errorf("isCommand(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const (
+	// compactionTarget is the fraction of the limits history is compacted
+	// down to, leaving headroom before the next compaction.
+	compactionTarget = 0.5
+
+	// compactKeepUnits is how many recent exchanges a manual compaction keeps.
+	compactKeepUnits = 2
+
+	// maxSummaryInputChars bounds each message in the transcript sent for
+	// summarization; large tool outputs rarely matter beyond their gist.
+	maxSummaryInputChars = 2000
+)
+
+const summarizeInstructions = `You compact the history of a coding assistant session.
+Write a concise summary of the conversation below so the assistant can continue the work without it.
+Keep: the user's goals and constraints, decisions made, files inspected or changed and why, commands run and their outcomes, open questions and next steps.
+Drop: pleasantries, verbatim file contents and tool output beyond what is needed to continue.
+Reply with the summary only.`
+
+// Compact summarizes everything except the most recent exchanges into the
+// pinned summary. It returns the number of messages that were compacted.
+func (a *Agent) Compact(ctx context.Context) (int, error) {
+	span := a.memory.HistoryBefore(compactKeepUnits)
+	if len(span) == 0 {
+		return 0, nil
+	}
+
+	if err := a.compactSpan(ctx, span); err != nil {
+		return 0, err
+	}
+	return len(span), nil
+}
+
+// compactIfNeeded summarizes the oldest history once it exceeds the context
+// budget. If summarization fails the overflow is discarded as it would be
+// without compaction, so the next request still fits.
+func (a *Agent) compactIfNeeded(ctx context.Context) {
+	if !a.memory.NeedsCompaction() {
+		return
+	}
+
+	span := a.memory.CompactionSpan(compactionTarget)
+	if len(span) == 0 {
+		return
+	}
+
+	if err := a.compactSpan(ctx, span); err != nil {
+		a.logger.Warn("Failed to summarize %d messages, discarding them instead: %v", len(span), err)
+		a.memory.Compact(len(span), a.memory.Summary())
+	}
+}
+
+func (a *Agent) compactSpan(ctx context.Context, span []openai.ChatCompletionMessageParamUnion) error {
+	a.logger.Debug("Compacting %d messages", len(span))
+
+	summary, err := a.summarize(ctx, a.memory.Summary(), span)
+	if err != nil {
+		return err
+	}
+
+	a.memory.Compact(len(span), summary)
+	a.logger.Info("Compacted %d messages into a summary", len(span))
+	a.notice("\u001b[90m📝 Compacted %d earlier messages into a summary:\n%s\u001b[0m", len(span), summary)
+	return nil
+}
+
+// summarize folds span into the previous summary. The transcript is sent in
+// chunks that fit the context budget, each chunk summarized together with the
+// summary of the chunks before it.
+func (a *Agent) summarize(ctx context.Context, previous string, span []openai.ChatCompletionMessageParamUnion) (string, error) {
+	lines := transcriptLines(span)
+	budget := a.memory.TokenBudget()
+	summary := previous
+
+	for len(lines) > 0 {
+		header := summaryHeader(summary)
+		used := a.tokenizer.CountTokens(summarizeInstructions) + a.tokenizer.CountTokens(header)
+		n := 0
+		for n < len(lines) {
+			cost := a.tokenizer.CountTokens(lines[n])
+			if budget > 0 && n > 0 && used+cost > budget {
+				break
+			}
+			used += cost
+			n++
+		}
+
+		var err error
+		summary, err = a.summarizeChunk(ctx, header+strings.Join(lines[:n], ""))
+		if err != nil {
+			return "", err
+		}
+		lines = lines[n:]
+	}
+	return summary, nil
+}
+
+// transcriptLines renders span as one line per message text and tool call.
+func transcriptLines(span []openai.ChatCompletionMessageParamUnion) []string {
+	var lines []string
+	for _, message := range span {
+		info := describeMessage(message)
+		if text := info.Text(); text != "" {
+			lines = append(lines, fmt.Sprintf("[%s] %s\n", info.Role, truncateForSummary(text)))
+		}
+		for _, call := range info.ToolCalls {
+			lines = append(lines, fmt.Sprintf("[%s called %s] %s\n", info.Role, call.Function.Name, truncateForSummary(call.Function.Arguments)))
+		}
+	}
+	return lines
+}
+
+func summaryHeader(previous string) string {
+	var header strings.Builder
+	if previous != "" {
+		header.WriteString("Summary of the conversation before this excerpt:\n")
+		header.WriteString(previous)
+		header.WriteString("\n\n")
+	}
+	header.WriteString("Conversation excerpt:\n")
+	return header.String()
+}
+
+func (a *Agent) summarizeChunk(ctx context.Context, transcript string) (string, error) {
+	params := openai.ChatCompletionNewParams{
+		Model:     a.config.Model,
+		MaxTokens: openai.Int(int64(a.config.MaxTokens)),
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(summarizeInstructions),
+			openai.UserMessage(transcript),
+		},
+	}
+
+	response, err := a.requestWithRetry(ctx, params, false)
+	if err != nil {
+		return "", fmt.Errorf("summarization request failed: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("summarization returned no choices")
+	}
+
+	summary := strings.TrimSpace(response.Choices[0].Message.Content)
+	if summary == "" {
+		return "", fmt.Errorf("summarization returned an empty summary")
+	}
+	return summary, nil
+}
+
+func truncateForSummary(text string) string {
+	if len(text) <= maxSummaryInputChars {
+		return text
+	}
+	cut := maxSummaryInputChars
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + fmt.Sprintf(" ... (%d more bytes)", len(text)-cut)
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+
+	"gocopilot/internal/config"
+	"gocopilot/internal/tools"
+)
+
+// summaryClient records the transcripts it is asked to summarize and answers
+// each with a numbered summary.
+type summaryClient struct {
+	transcripts []string
+}
+
+func (c *summaryClient) ChatCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	c.transcripts = append(c.transcripts, describeMessage(params.Messages[1]).Text())
+	return &openai.ChatCompletion{
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Content: fmt.Sprintf("summary %d", len(c.transcripts))},
+		}},
+	}, nil
+}
+
+func TestSummarizeSplitsLongSpansToFitTheBudget(t *testing.T) {
+	client := &summaryClient{}
+	cfg := &config.Config{MemoryCapacity: 100, MaxConcurrency: 1}
+	a := NewAgent(client, nil, nil, tools.NewRegistry(), cfg, nil)
+	a.SetTokenizer(charTokenizer{})
+	const budget = 5000
+	a.memory.SetTokenBudget(budget)
+
+	var span []openai.ChatCompletionMessageParamUnion
+	for i := 0; i < 20; i++ {
+		span = append(span, openai.UserMessage(fmt.Sprintf("message %02d %s", i, strings.Repeat("x", 1500))))
+	}
+
+	summary, err := a.summarize(context.Background(), "earlier work", span)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.transcripts) < 2 {
+		t.Fatalf("%d summarization requests, want the span split", len(client.transcripts))
+	}
+	if want := fmt.Sprintf("summary %d", len(client.transcripts)); summary != want {
+		t.Errorf("summary = %q, want %q", summary, want)
+	}
+
+	previous := "earlier work"
+	seen := map[int]int{}
+	for i, transcript := range client.transcripts {
+		if size := len(summarizeInstructions) + len(transcript); size > budget {
+			t.Errorf("request %d is %d tokens, over the budget of %d", i, size, budget)
+		}
+		if !strings.Contains(transcript, previous) {
+			t.Errorf("request %d does not carry the summary %q", i, previous)
+		}
+		previous = fmt.Sprintf("summary %d", i+1)
+		for m := 0; m < len(span); m++ {
+			if strings.Contains(transcript, fmt.Sprintf("message %02d ", m)) {
+				seen[m]++
+			}
+		}
+	}
+	for m := 0; m < len(span); m++ {
+		if seen[m] != 1 {
+			t.Errorf("message %d was sent %d times", m, seen[m])
+		}
+	}
+}
@@ -3,11 +3,16 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/openai/openai-go/v3"
 
+	"gocopilot/internal/permission"
 	"gocopilot/internal/tools"
 )
 
@@ -15,6 +20,13 @@ type ToolExecutor struct {
 	registry   *tools.Registry
 	maxWorkers int
 	logger     Logger
+	workspace  *tools.Workspace
+	output     io.Writer
+	gate       *permission.Gate
+	journal    *tools.Journal
+
+	defaultTimeout time.Duration
+	timeouts       map[string]time.Duration
 }
 
 func NewToolExecutor(registry *tools.Registry, maxWorkers int, logger Logger) *ToolExecutor {
@@ -29,6 +41,85 @@ func NewToolExecutor(registry *tools.Registry, maxWorkers int, logger Logger) *T
 	}
 }
 
+// SetWorkspace sets the directories file tools are confined to.
+func (e *ToolExecutor) SetWorkspace(workspace *tools.Workspace) {
+	e.workspace = workspace
+}
+
+// SetOutput sets where tools write progress while they run.
+func (e *ToolExecutor) SetOutput(output io.Writer) {
+	e.output = output
+}
+
+// SetPermissions sets the gate consulted before each tool call. Without one,
+// every call runs.
+func (e *ToolExecutor) SetPermissions(gate *permission.Gate) {
+	e.gate = gate
+}
+
+// SetJournal sets the journal file changes made by tools are recorded in.
+func (e *ToolExecutor) SetJournal(journal *tools.Journal) {
+	e.journal = journal
+}
+
+// SetTimeouts sets the time limit for tools that do not declare one and
+// per-tool overrides that take precedence over the tool's own default. A zero
+// duration disables the limit.
+func (e *ToolExecutor) SetTimeouts(defaultTimeout time.Duration, overrides map[string]time.Duration) {
+	e.defaultTimeout = defaultTimeout
+	e.timeouts = overrides
+}
+
+// timeoutFor returns the time limit for a call to the named tool.
+func (e *ToolExecutor) timeoutFor(name string) time.Duration {
+	if timeout, ok := e.timeouts[name]; ok {
+		return timeout
+	}
+	if tool, ok := e.registry.Get(name); ok && tool.Timeout > 0 {
+		return tool.Timeout
+	}
+	return e.defaultTimeout
+}
+
+// checkPermission asks the gate whether a call may run. Calls of unknown
+// tools are left to fail in the registry.
+func (e *ToolExecutor) checkPermission(ctx context.Context, callID, toolName string, arguments json.RawMessage) (permission.Decision, bool) {
+	tool, exists := e.registry.Get(toolName)
+	if e.gate == nil || !exists {
+		return permission.Decision{Allowed: true}, true
+	}
+
+	req := permission.Request{
+		Tool:      toolName,
+		Arguments: arguments,
+		ReadOnly:  tool.ReadOnly,
+		CallID:    callID,
+	}
+	if tool.Targets != nil {
+		targets := tool.Targets(arguments)
+		req.Command = targets.Command
+		for _, p := range targets.Paths {
+			req.Paths = append(req.Paths, e.rulePath(p))
+		}
+	}
+
+	decision := e.gate.Check(ctx, req)
+	return decision, decision.Allowed
+}
+
+// rulePath makes a path relative to the workspace root, as permission rules
+// expect. Paths outside the workspace are left as given; the tool rejects them.
+func (e *ToolExecutor) rulePath(p string) string {
+	workspace := e.workspace
+	if workspace == nil {
+		workspace = tools.NewWorkspace(".")
+	}
+	if resolved, err := workspace.Resolve(p); err == nil {
+		return workspace.Rel(resolved)
+	}
+	return filepath.ToSlash(filepath.Clean(p))
+}
+
 func (e *ToolExecutor) ExecuteToolCalls(
 	ctx context.Context,
 	toolCalls []openai.ChatCompletionMessageToolCallUnion,
@@ -53,33 +144,76 @@ func (e *ToolExecutor) ExecuteToolCalls(
 			go func(index int, callID, toolName string, arguments json.RawMessage) {
 				defer wg.Done()
 
-				// Acquire semaphore
-				semaphore <- struct{}{}
-				defer func() { <-semaphore }()
+				cancelled := tools.ToolResult{
+					Output: "tool execution cancelled",
+					Error:  fmt.Errorf("tool execution cancelled: %w", context.Canceled),
+					CallID: callID,
+				}
 
-				// Check if context is cancelled
-				select {
-				case <-ctx.Done():
+				if decision, ok := e.checkPermission(ctx, callID, toolName, arguments); !ok {
 					results[index] = tools.ToolResult{
-						Output: fmt.Sprintf("tool execution cancelled: %v", ctx.Err()),
-						Error:  ctx.Err(),
+						Output: decision.Reason,
+						Error:  decision.Err(),
 						CallID: callID,
 					}
 					return
-				default:
 				}
 
-				output, err := e.registry.ExecuteTool(toolName, arguments, e.logger)
-				results[index] = tools.ToolResult{
-					Output: output,
-					Error:  err,
-					CallID: callID,
+				// Acquire semaphore unless the turn is cancelled while waiting
+				select {
+				case semaphore <- struct{}{}:
+				case <-ctx.Done():
+					results[index] = cancelled
+					return
+				}
+				defer func() { <-semaphore }()
+
+				if ctx.Err() != nil {
+					results[index] = cancelled
+					return
+				}
+
+				timeout := e.timeoutFor(toolName)
+				callCtx, cancel := ctx, context.CancelFunc(func() {})
+				if timeout > 0 {
+					callCtx, cancel = context.WithTimeout(ctx, timeout)
 				}
+				defer cancel()
 
-				if err != nil {
-					e.logger.Warn("Tool execution failed: %s, error: %v", toolName, err)
+				// Tools that ignore the context are abandoned on cancellation or
+				// timeout so the turn can end without waiting for them
+				done := make(chan tools.ToolResult, 1)
+				go func() {
+					env := tools.NewEnv(e.workspace, e.logger, e.output, callID)
+					env.Journal = e.journal
+					output, err := e.registry.ExecuteTool(callCtx, env, toolName, arguments)
+					done <- tools.ToolResult{Output: output, Error: err, CallID: callID}
+				}()
+
+				var result tools.ToolResult
+				select {
+				case result = <-done:
+				case <-callCtx.Done():
+					result = tools.ToolResult{Error: callCtx.Err(), CallID: callID}
+				}
+
+				switch {
+				case result.Error == nil:
+				case ctx.Err() != nil:
+					e.logger.Warn("Tool execution cancelled: %s", toolName)
+					result = cancelled
+				case errors.Is(callCtx.Err(), context.DeadlineExceeded):
+					result = tools.ToolResult{
+						Error:  &tools.TimeoutError{Tool: toolName, Timeout: timeout},
+						CallID: callID,
+					}
+				}
+				results[index] = result
+
+				if result.Error != nil {
+					e.logger.Warn("Tool execution failed: %s, error: %v", toolName, result.Error)
 				} else {
-					e.logger.Debug("Tool execution successful: %s, output length: %d", toolName, len(output))
+					e.logger.Debug("Tool execution successful: %s, output length: %d", toolName, len(result.Output))
 				}
 			}(idx, tc.ID, tc.Function.Name, json.RawMessage(tc.Function.Arguments))
 
@@ -111,7 +245,10 @@ func (e *ToolExecutor) ExecuteToolCalls(
 		}
 
 		var content string
-		if result.Error != nil {
+		var timeoutErr *tools.TimeoutError
+		if errors.As(result.Error, &timeoutErr) {
+			content = timeoutErr.ToolMessage()
+		} else if result.Error != nil {
 			content = fmt.Sprintf("Error: %s", result.Error.Error())
 		} else {
 			content = result.Output
@@ -121,4 +258,19 @@ func (e *ToolExecutor) ExecuteToolCalls(
 	}
 
 	return messages, nil
-}
\ No newline at end of file
+}
+
+// toolOutputWriter forwards live tool output to a ToolOutputHandler. Calls
+// running concurrently share it, so writes are serialized.
+type toolOutputWriter struct {
+	mu      sync.Mutex
+	handler ToolOutputHandler
+}
+
+func (w *toolOutputWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.handler.PrintToolOutput(string(p))
+	return len(p), nil
+}
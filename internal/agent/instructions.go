@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"gocopilot/internal/instructions"
+)
+
+// systemPrompt loads the instruction files for the workspace and merges them
+// with SYSTEM_MESSAGE. Files that cannot be read are skipped with a warning.
+func (a *Agent) systemPrompt() string {
+	a.promptFiles = nil
+	if a.config.InstructionFiles {
+		root := a.config.WorkspaceRoot
+		if a.executor.workspace != nil {
+			root = a.executor.workspace.Root()
+		}
+		files, err := instructions.Discover(root, a.config.UserInstructions)
+		if err != nil {
+			a.logger.Warn("Failed to read instruction files: %v", err)
+		}
+		for _, file := range files {
+			a.logger.Debug("Loaded instructions from %s", file.Path)
+		}
+		a.promptFiles = files
+	}
+	return instructions.Compose(a.promptFiles, a.config.SystemMessage)
+}
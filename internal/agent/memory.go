@@ -8,15 +8,48 @@ import (
 
 const DefaultMemoryCapacity = 40
 
+// summaryPrefix introduces the pinned summary of compacted history.
+const summaryPrefix = "Summary of the earlier conversation, which has been compacted:\n\n"
+
 type Memory struct {
-	mu         sync.RWMutex
-	system     []openai.ChatCompletionMessageParamUnion
-	history    []openai.ChatCompletionMessageParamUnion
-	maxHistory int
+	mu            sync.RWMutex
+	system        []openai.ChatCompletionMessageParamUnion
+	systemTokens  int
+	summary       string
+	summaryTokens int
+	history       []memoryEntry
+	maxHistory    int
+	tokenizer     Tokenizer
+	tokenBudget   int
+	compaction    bool
+}
+
+// memoryEntry caches the parts of a message that trimming depends on, so the
+// message does not have to be re-encoded every time history is trimmed.
+type memoryEntry struct {
+	message openai.ChatCompletionMessageParamUnion
+	role    string
+	tokens  int
+}
+
+func (m *Memory) newEntryLocked(message openai.ChatCompletionMessageParamUnion) memoryEntry {
+	info := describeMessage(message)
+	return memoryEntry{
+		message: message,
+		role:    info.Role,
+		tokens:  countMessageTokens(m.tokenizer, info),
+	}
+}
+
+// startsUnit reports whether the entry begins a new trimming unit. Tool
+// responses belong to the assistant message that requested them and never
+// start a unit of their own.
+func (e memoryEntry) startsUnit() bool {
+	return e.role != "tool"
 }
 
 func NewMemory(maxHistory int) *Memory {
-	m := &Memory{}
+	m := &Memory{tokenizer: HeuristicTokenizer{}}
 	if maxHistory > 0 {
 		m.maxHistory = maxHistory
 	}
@@ -27,20 +60,15 @@ func (m *Memory) SetSystemMessages(messages ...openai.ChatCompletionMessageParam
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if len(messages) == 0 {
-		m.system = nil
-		return
-	}
-
-	m.system = make([]openai.ChatCompletionMessageParamUnion, len(messages))
-	copy(m.system, messages)
+	m.setSystemLocked(messages)
+	m.trimLocked()
 }
 
 func (m *Memory) Append(message openai.ChatCompletionMessageParamUnion) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.history = append(m.history, message)
+	m.history = append(m.history, m.newEntryLocked(message))
 	m.trimLocked()
 }
 
@@ -52,10 +80,52 @@ func (m *Memory) AppendMany(messages []openai.ChatCompletionMessageParamUnion) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.history = append(m.history, messages...)
+	for _, message := range messages {
+		m.history = append(m.history, m.newEntryLocked(message))
+	}
 	m.trimLocked()
 }
 
+// CloseDanglingToolCalls answers tool calls of the latest assistant message
+// that have no tool response yet, as happens when a turn is interrupted, so
+// the history stays valid for the next request. It returns how many responses
+// were added.
+func (m *Memory) CloseDanglingToolCalls(content string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	last := -1
+	for i := len(m.history) - 1; i >= 0; i-- {
+		if m.history[i].role == "assistant" {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return 0
+	}
+
+	answered := make(map[string]bool)
+	for _, entry := range m.history[last+1:] {
+		if entry.role == "tool" {
+			answered[describeMessage(entry.message).ToolCallID] = true
+		}
+	}
+
+	added := 0
+	for _, call := range describeMessage(m.history[last].message).ToolCalls {
+		if answered[call.ID] {
+			continue
+		}
+		m.history = append(m.history, m.newEntryLocked(openai.ToolMessage(content, call.ID)))
+		added++
+	}
+	if added > 0 {
+		m.trimLocked()
+	}
+	return added
+}
+
 func (m *Memory) TrimTo(maxHistory int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -64,11 +134,159 @@ func (m *Memory) TrimTo(maxHistory int) {
 	m.trimLocked()
 }
 
+// SetTokenBudget limits the estimated size of the whole context, system
+// messages included. A budget of zero or less disables token-based trimming.
+func (m *Memory) SetTokenBudget(budget int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokenBudget = budget
+	m.trimLocked()
+}
+
+// TokenBudget returns the budget set with SetTokenBudget.
+func (m *Memory) TokenBudget() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.tokenBudget
+}
+
+// SetTokenizer replaces the tokenizer used for budgeting and re-estimates
+// every stored message with it.
+func (m *Memory) SetTokenizer(tokenizer Tokenizer) {
+	if tokenizer == nil {
+		tokenizer = HeuristicTokenizer{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokenizer = tokenizer
+	m.setSystemLocked(m.system)
+	for i := range m.history {
+		m.history[i] = m.newEntryLocked(m.history[i].message)
+	}
+	m.setSummaryLocked(m.summary)
+	m.trimLocked()
+}
+
+// TokenCount returns the estimated token size of the current context.
+func (m *Memory) TokenCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	total := m.systemTokens + m.summaryTokens
+	for _, entry := range m.history {
+		total += entry.tokens
+	}
+	return total
+}
+
+// SetCompaction switches between discarding old history and keeping it until
+// the owner summarizes it with Compact. While enabled, history may exceed the
+// limits; NeedsCompaction reports when it does.
+func (m *Memory) SetCompaction(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.compaction = enabled
+	m.trimLocked()
+}
+
+// Summary returns the pinned summary of compacted history, if any.
+func (m *Memory) Summary() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.summary
+}
+
+// NeedsCompaction reports whether history exceeds the message or token limits.
+func (m *Memory) NeedsCompaction() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.evictionPointLocked(1) > 0
+}
+
+// CompactionSpan returns the oldest messages that have to be removed for the
+// rest of history to fit within ratio of the message and token limits.
+// Summarizing down to a fraction of the limits leaves headroom, so the next
+// compaction is not needed on the very next message.
+func (m *Memory) CompactionSpan(ratio float64) []openai.ChatCompletionMessageParamUnion {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.messagesLocked(m.evictionPointLocked(ratio))
+}
+
+// HistoryBefore returns all messages preceding the newest keepUnits units,
+// where a unit is a message together with any tool responses it triggered.
+func (m *Memory) HistoryBefore(keepUnits int) []openai.ChatCompletionMessageParamUnion {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cut := len(m.history)
+	for i := len(m.history) - 1; i >= 0 && keepUnits > 0; i-- {
+		if m.history[i].startsUnit() {
+			cut = i
+			keepUnits--
+		}
+	}
+	if keepUnits > 0 {
+		return nil
+	}
+	return m.messagesLocked(cut)
+}
+
+// Compact replaces the oldest count history messages with summary, which is
+// pinned ahead of the remaining history. The summary should cover any
+// previous summary as well, since it is replaced.
+func (m *Memory) Compact(count int, summary string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if count > len(m.history) {
+		count = len(m.history)
+	}
+
+	m.history = append([]memoryEntry(nil), m.history[count:]...)
+	m.setSummaryLocked(summary)
+	m.trimLocked()
+}
+
+// Snapshot returns copies of the system messages, summary and history.
+func (m *Memory) Snapshot() (system []openai.ChatCompletionMessageParamUnion, summary string, history []openai.ChatCompletionMessageParamUnion) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	system = append([]openai.ChatCompletionMessageParamUnion(nil), m.system...)
+	return system, m.summary, m.messagesLocked(len(m.history))
+}
+
+// Restore replaces the whole memory contents, e.g. when resuming a session.
+func (m *Memory) Restore(system []openai.ChatCompletionMessageParamUnion, summary string, history []openai.ChatCompletionMessageParamUnion) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setSystemLocked(system)
+
+	m.history = make([]memoryEntry, 0, len(history))
+	for _, message := range history {
+		m.history = append(m.history, m.newEntryLocked(message))
+	}
+
+	m.setSummaryLocked(summary)
+	m.trimLocked()
+}
+
 func (m *Memory) ResetHistory() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.history = nil
+	m.setSummaryLocked("")
 }
 
 func (m *Memory) Context() []openai.ChatCompletionMessageParamUnion {
@@ -76,13 +294,21 @@ func (m *Memory) Context() []openai.ChatCompletionMessageParamUnion {
 	defer m.mu.RUnlock()
 
 	total := len(m.system) + len(m.history)
+	if m.summary != "" {
+		total++
+	}
 	if total == 0 {
 		return nil
 	}
 
-	out := make([]openai.ChatCompletionMessageParamUnion, total)
-	copy(out, m.system)
-	copy(out[len(m.system):], m.history)
+	out := make([]openai.ChatCompletionMessageParamUnion, 0, total)
+	out = append(out, m.system...)
+	if m.summary != "" {
+		out = append(out, summaryMessage(m.summary))
+	}
+	for _, entry := range m.history {
+		out = append(out, entry.message)
+	}
 	return out
 }
 
@@ -90,18 +316,111 @@ func (m *Memory) MessageCount() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	return len(m.system) + len(m.history)
+	count := len(m.system) + len(m.history)
+	if m.summary != "" {
+		count++
+	}
+	return count
 }
 
-func (m *Memory) trimLocked() {
-	if m.maxHistory <= 0 {
+func summaryMessage(summary string) openai.ChatCompletionMessageParamUnion {
+	return openai.SystemMessage(summaryPrefix + summary)
+}
+
+func (m *Memory) setSystemLocked(messages []openai.ChatCompletionMessageParamUnion) {
+	m.system = nil
+	m.systemTokens = 0
+	if len(messages) == 0 {
 		return
 	}
 
-	if len(m.history) <= m.maxHistory {
+	m.system = make([]openai.ChatCompletionMessageParamUnion, len(messages))
+	copy(m.system, messages)
+	for _, message := range messages {
+		m.systemTokens += countMessageTokens(m.tokenizer, describeMessage(message))
+	}
+}
+
+func (m *Memory) setSummaryLocked(summary string) {
+	m.summary = summary
+	m.summaryTokens = 0
+	if summary != "" {
+		m.summaryTokens = countMessageTokens(m.tokenizer, describeMessage(summaryMessage(summary)))
+	}
+}
+
+func (m *Memory) messagesLocked(count int) []openai.ChatCompletionMessageParamUnion {
+	if count == 0 {
+		return nil
+	}
+
+	out := make([]openai.ChatCompletionMessageParamUnion, count)
+	for i, entry := range m.history[:count] {
+		out[i] = entry.message
+	}
+	return out
+}
+
+// trimLocked drops the oldest history until at most maxHistory messages
+// remain and the estimated context fits the token budget. With compaction
+// enabled only orphaned tool responses are dropped, and the owner is expected
+// to summarize the overflow instead.
+func (m *Memory) trimLocked() {
+	cut := m.leadingOrphansLocked()
+	if !m.compaction {
+		cut = m.evictionPointLocked(1)
+	}
+
+	if cut == 0 {
 		return
 	}
 
-	keep := m.history[len(m.history)-m.maxHistory:]
-	m.history = append([]openai.ChatCompletionMessageParamUnion(nil), keep...)
+	m.history = append([]memoryEntry(nil), m.history[cut:]...)
+}
+
+// evictionPointLocked returns how many leading history messages have to go
+// for the rest to fit within ratio of the limits. An assistant message with
+// tool calls and the tool responses that follow it are removed together, and
+// history never starts with a tool response, since the API rejects tool
+// messages whose originating call is missing. If the newest unit alone
+// exceeds the limits it is kept whole.
+func (m *Memory) evictionPointLocked(ratio float64) int {
+	cut := m.leadingOrphansLocked()
+
+	remaining := m.systemTokens + m.summaryTokens
+	for _, entry := range m.history[cut:] {
+		remaining += entry.tokens
+	}
+
+	last := cut
+	for i := cut; i < len(m.history); i++ {
+		if m.history[i].startsUnit() {
+			last = i
+			if m.fitsLocked(len(m.history)-i, remaining, ratio) {
+				break
+			}
+		}
+		remaining -= m.history[i].tokens
+	}
+	return last
+}
+
+func (m *Memory) fitsLocked(messages, tokens int, ratio float64) bool {
+	if m.maxHistory > 0 && float64(messages) > float64(m.maxHistory)*ratio {
+		return false
+	}
+	if m.tokenBudget > 0 && float64(tokens) > float64(m.tokenBudget)*ratio {
+		return false
+	}
+	return true
+}
+
+// leadingOrphansLocked counts tool responses at the start of history that no
+// longer have their assistant message.
+func (m *Memory) leadingOrphansLocked() int {
+	n := 0
+	for n < len(m.history) && !m.history[n].startsUnit() {
+		n++
+	}
+	return n
 }
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// assistantWithCalls returns an assistant message requesting the given tool
+// call IDs.
+func assistantWithCalls(t *testing.T, ids ...string) openai.ChatCompletionMessageParamUnion {
+	t.Helper()
+	var calls []map[string]any
+	for _, id := range ids {
+		calls = append(calls, map[string]any{
+			"id": id, "type": "function",
+			"function": map[string]any{"name": "read_file", "arguments": `{"path":"a.go"}`},
+		})
+	}
+	data, err := json.Marshal(map[string]any{"role": "assistant", "content": "", "tool_calls": calls})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message openai.ChatCompletionMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatal(err)
+	}
+	return message.ToParam()
+}
+
+// checkToolPairing fails if history starts with a tool response or holds a
+// tool response whose assistant message is missing.
+func checkToolPairing(t *testing.T, m *Memory) {
+	t.Helper()
+	_, _, history := m.Snapshot()
+	if len(history) > 0 && describeMessage(history[0]).Role == "tool" {
+		t.Fatalf("history starts with a tool response")
+	}
+
+	pending := map[string]bool{}
+	for _, message := range history {
+		info := describeMessage(message)
+		switch info.Role {
+		case "assistant":
+			pending = map[string]bool{}
+			for _, call := range info.ToolCalls {
+				pending[call.ID] = true
+			}
+		case "tool":
+			if !pending[info.ToolCallID] {
+				t.Fatalf("tool response %s is separated from its assistant message", info.ToolCallID)
+			}
+		default:
+			pending = map[string]bool{}
+		}
+	}
+}
+
+func TestTrimKeepsToolCallsWithTheirResponses(t *testing.T) {
+	for limit := 1; limit <= 8; limit++ {
+		m := NewMemory(limit)
+		for turn := 0; turn < 6; turn++ {
+			m.Append(openai.UserMessage(fmt.Sprintf("question %d", turn)))
+			checkToolPairing(t, m)
+
+			first, second := fmt.Sprintf("call_%d_a", turn), fmt.Sprintf("call_%d_b", turn)
+			m.AppendMany([]openai.ChatCompletionMessageParamUnion{
+				assistantWithCalls(t, first, second),
+				openai.ToolMessage("result a", first),
+				openai.ToolMessage("result b", second),
+			})
+			checkToolPairing(t, m)
+
+			m.Append(openai.AssistantMessage("answer"))
+			checkToolPairing(t, m)
+		}
+		if count := m.MessageCount(); count > max(limit, 3) {
+			t.Errorf("limit %d: %d messages kept", limit, count)
+		}
+	}
+}
+
+func TestTrimDropsOrphanedToolResponses(t *testing.T) {
+	m := NewMemory(10)
+	m.Restore(nil, "", []openai.ChatCompletionMessageParamUnion{
+		openai.ToolMessage("orphan", "call_gone"),
+		openai.UserMessage("hello"),
+	})
+	checkToolPairing(t, m)
+	if count := m.MessageCount(); count != 1 {
+		t.Errorf("%d messages kept, want 1", count)
+	}
+}
+
+func TestTrimKeepsNewestUnitThatExceedsLimit(t *testing.T) {
+	m := NewMemory(2)
+	m.Append(openai.UserMessage("read three files"))
+	m.AppendMany([]openai.ChatCompletionMessageParamUnion{
+		assistantWithCalls(t, "call_1", "call_2", "call_3"),
+		openai.ToolMessage("one", "call_1"),
+		openai.ToolMessage("two", "call_2"),
+		openai.ToolMessage("three", "call_3"),
+	})
+
+	_, _, history := m.Snapshot()
+	if len(history) != 4 {
+		t.Fatalf("%d messages kept, want the whole 4 message unit", len(history))
+	}
+	if info := describeMessage(history[0]); info.Role != "assistant" || len(info.ToolCalls) != 3 {
+		t.Errorf("history starts with %s message", info.Role)
+	}
+	checkToolPairing(t, m)
+}
+
+// charTokenizer counts one token per character, so costs are easy to follow.
+type charTokenizer struct{}
+
+func (charTokenizer) CountTokens(text string) int {
+	return len(text)
+}
+
+func TestTokenBudgetEvictsByCost(t *testing.T) {
+	m := NewMemory(0)
+	m.SetTokenizer(charTokenizer{})
+
+	big := string(make([]byte, 500))
+	m.Append(openai.UserMessage(big))
+	for i := 0; i < 10; i++ {
+		m.Append(openai.UserMessage("short"))
+	}
+
+	// 11 messages cost 4+500 + 10*(4+5) = 594 tokens; a budget of 200 only
+	// needs the big message to go, while all the short ones fit
+	m.SetTokenBudget(200)
+	_, _, history := m.Snapshot()
+	if len(history) != 10 {
+		t.Fatalf("%d messages kept, want the 10 short ones", len(history))
+	}
+	for _, message := range history {
+		if describeMessage(message).Text() != "short" {
+			t.Fatalf("the big message was kept")
+		}
+	}
+	if tokens := m.TokenCount(); tokens != 90 {
+		t.Errorf("token count = %d, want 90", tokens)
+	}
+
+	// A tighter budget drops just as many messages as needed
+	m.SetTokenBudget(45)
+	if count := m.MessageCount(); count != 5 {
+		t.Errorf("%d messages kept with a budget of 45, want 5", count)
+	}
+}
+
+func TestTokenBudgetNeverEvictsPinnedMessages(t *testing.T) {
+	m := NewMemory(0)
+	m.SetTokenizer(charTokenizer{})
+	m.SetSystemMessages(openai.SystemMessage("You are a careful assistant."))
+	m.Compact(0, "The user is refactoring the parser.")
+
+	m.SetTokenBudget(50)
+	for i := 0; i < 20; i++ {
+		m.Append(openai.UserMessage(fmt.Sprintf("message %d", i)))
+	}
+
+	system, summary, history := m.Snapshot()
+	if len(system) != 1 || summary != "The user is refactoring the parser." {
+		t.Fatalf("pinned messages were evicted: system %d, summary %q", len(system), summary)
+	}
+	// system and summary alone exceed the budget, so only the newest
+	// message is left in history
+	if len(history) != 1 || describeMessage(history[0]).Text() != "message 19" {
+		t.Errorf("history = %d messages, want only the newest", len(history))
+	}
+}
@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"encoding/json"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// messageInfo is a flattened view of a chat message param.
+//
+// Params built from a response via ToParam carry their data in fields, while
+// params built by hand may only be populated through constructors, so the
+// reliable way to inspect any of them is through their JSON encoding.
+type messageInfo struct {
+	Role       string            `json:"role"`
+	Content    json.RawMessage   `json:"content,omitempty"`
+	ToolCalls  []messageToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+}
+
+type messageToolCall struct {
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+func describeMessage(message openai.ChatCompletionMessageParamUnion) messageInfo {
+	var info messageInfo
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return info
+	}
+	_ = json.Unmarshal(data, &info)
+	return info
+}
+
+// Text returns the message content, joining text parts of array content.
+func (m messageInfo) Text() string {
+	if len(m.Content) == 0 {
+		return ""
+	}
+
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text
+	}
+
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return ""
+	}
+
+	for _, part := range parts {
+		text += part.Text
+	}
+	return text
+}
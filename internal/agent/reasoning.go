@@ -55,7 +55,14 @@ func (rc *ReasoningChain) Execute(
     for step := 0; step < rc.maxSteps; step++ {
         rc.logger.Debug("Reasoning step %d", step+1)
 
-        response, err := agent.runInference(ctx, agent.memory.Context())
+		// When streaming, content arrives during inference, so the step
+		// header has to be printed before the type is known.
+		streaming := agent.streamingEnabled()
+		if streaming {
+			agent.notice("\u001b[35m🧠 Step %d\u001b[0m", step+1)
+		}
+
+		response, err := agent.runInference(ctx)
         if err != nil {
             return "", fmt.Errorf("reasoning step %d failed: %w", step+1, err)
         }
@@ -73,10 +80,12 @@ func (rc *ReasoningChain) Execute(
         agent.memory.Append(message.ToParam())
 
         // Print a visible step header in the terminal
-        fmt.Printf("\u001b[35m🧠 Step %d [%s]\u001b[0m\n", step+1, string(stepType))
+		if !streaming {
+			agent.notice("\u001b[35m🧠 Step %d [%s]\u001b[0m", step+1, string(stepType))
+		}
 
         // Handle assistant message
-        if message.Content != "" {
+		if message.Content != "" && !streaming {
             agent.output.PrintAssistantMessage(message.Content)
         }
 
@@ -100,6 +109,10 @@ func (rc *ReasoningChain) Execute(
                 agent.memory.Append(toolMsg)
             }
 
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+
             // Continue to next reasoning step
             continue
         }
@@ -118,7 +131,7 @@ func (rc *ReasoningChain) Execute(
 	}
 
 	rc.logger.Warn("Reasoning chain reached maximum steps (%d) without completion", rc.maxSteps)
-	return "", fmt.Errorf("reasoning chain exceeded maximum steps (%d)", rc.maxSteps)
+	return "", fmt.Errorf("%w: reasoning chain exceeded maximum steps (%d)", ErrMaxStepsExceeded, rc.maxSteps)
 }
 
 func (rc *ReasoningChain) analyzeStepType(message openai.ChatCompletionMessage) StepType {
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+
+	"gocopilot/internal/tools"
+)
+
+// refreshRepoMap rebuilds the repository map and replaces the one among the
+// system messages if the workspace changed. It is a no-op when the map is
+// disabled with REPO_MAP_TOKENS=0.
+func (a *Agent) refreshRepoMap(ctx context.Context) {
+	if a.config.RepoMapTokens <= 0 {
+		return
+	}
+
+	workspace := a.executor.workspace
+	if workspace == nil {
+		workspace = tools.NewWorkspace(a.config.WorkspaceRoot)
+	}
+	repoMap, err := tools.RepoMap(ctx, workspace, a.config.RepoMapTokens, a.tokenizer.CountTokens)
+	if err != nil {
+		a.logger.Warn("Failed to build repository map: %v", err)
+		return
+	}
+
+	system, _, _ := a.memory.Snapshot()
+	kept := make([]openai.ChatCompletionMessageParamUnion, 0, len(system)+1)
+	for _, message := range system {
+		text := describeMessage(message).Text()
+		if text == repoMap {
+			return
+		}
+		if !strings.HasPrefix(text, tools.RepoMapHeader) {
+			kept = append(kept, message)
+		}
+	}
+
+	a.memory.SetSystemMessages(append(kept, openai.SystemMessage(repoMap))...)
+	a.logger.Debug("Updated repository map (~%d tokens)", a.tokenizer.CountTokens(repoMap))
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// maxRetryAfter caps server supplied Retry-After hints so a misbehaving
+// gateway cannot stall the session indefinitely.
+const maxRetryAfter = 2 * time.Minute
+
+// errRequestTimeout is returned when a single inference request exceeds the
+// configured REQUEST_TIMEOUT. It is retryable, unlike cancellation of the
+// caller's context.
+var errRequestTimeout = errors.New("inference request timed out")
+
+// RetryPolicy controls how failed inference calls are retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    20 * time.Second,
+	}
+}
+
+// Backoff returns the delay before the given retry (1-based) using
+// exponential backoff with equal jitter. jitter must return a value in [0, 1).
+func (p RetryPolicy) Backoff(retry int, jitter func() float64) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	// Keep at least half of the computed delay so retries never fire back-to-back.
+	half := delay / 2
+	return half + time.Duration(jitter()*float64(delay-half))
+}
+
+// IsRetryableError reports whether an inference error is transient.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, errRequestTimeout) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == "insufficient_quota" {
+			return false
+		}
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+			return true
+		}
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// url.Error satisfies net.Error for every failure, including permanent
+	// ones such as a malformed base URL, so only timeouts and errors from the
+	// network itself are treated as transient.
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// retryAfter extracts the server's requested delay from a rate limit or
+// overload response, if any.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) || apiErr.Response == nil {
+		return 0, false
+	}
+
+	header := apiErr.Response.Header
+	if ms := header.Get("Retry-After-Ms"); ms != "" {
+		if v, err := strconv.ParseFloat(ms, 64); err == nil && v >= 0 {
+			return capRetryAfter(time.Duration(v * float64(time.Millisecond))), true
+		}
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return capRetryAfter(time.Duration(seconds * float64(time.Second))), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return capRetryAfter(time.Until(at)), true
+	}
+	return 0, false
+}
+
+func capRetryAfter(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func defaultJitter() float64 {
+	return rand.Float64()
+}
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+
+	"gocopilot/internal/config"
+	"gocopilot/internal/tools"
+)
+
+// apiError builds an SDK error as the client returns it for an HTTP response.
+func apiError(status int, code string, header http.Header) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/v1/chat/completions", nil)
+	return &openai.Error{
+		Code:       code,
+		StatusCode: status,
+		Request:    req,
+		Response:   &http.Response{StatusCode: status, Header: header},
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"request timeout", fmt.Errorf("%w after 30s", errRequestTimeout), true},
+		{"canceled", context.Canceled, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"408", apiError(http.StatusRequestTimeout, "", nil), true},
+		{"409", apiError(http.StatusConflict, "", nil), true},
+		{"429", apiError(http.StatusTooManyRequests, "", nil), true},
+		{"429 insufficient quota", apiError(http.StatusTooManyRequests, "insufficient_quota", nil), false},
+		{"500", apiError(http.StatusInternalServerError, "", nil), true},
+		{"503", apiError(http.StatusServiceUnavailable, "", nil), true},
+		{"400", apiError(http.StatusBadRequest, "", nil), false},
+		{"401", apiError(http.StatusUnauthorized, "", nil), false},
+		{"unexpected EOF", fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), true},
+		{"connection refused", &url.Error{Op: "Post", URL: "https://api.example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, true},
+		{"network timeout", &url.Error{Op: "Post", URL: "https://api.example.com", Err: timeoutError{}}, true},
+		{"unsupported scheme", &url.Error{Op: "Post", URL: "ftp://api.example.com", Err: errors.New("unsupported protocol scheme")}, false},
+		{"other", errors.New("invalid request"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryableError(tt.err); got != tt.want {
+				t.Errorf("IsRetryableError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   time.Duration
+		wantOK bool
+	}{
+		{"no header", apiError(http.StatusTooManyRequests, "", nil), 0, false},
+		{"not an API error", errors.New("boom"), 0, false},
+		{"milliseconds", apiError(http.StatusTooManyRequests, "", http.Header{"Retry-After-Ms": {"1500"}}), 1500 * time.Millisecond, true},
+		{"seconds", apiError(http.StatusTooManyRequests, "", http.Header{"Retry-After": {"3"}}), 3 * time.Second, true},
+		{"milliseconds take precedence", apiError(http.StatusTooManyRequests, "", http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"3"}}), 250 * time.Millisecond, true},
+		{"seconds capped", apiError(http.StatusServiceUnavailable, "", http.Header{"Retry-After": {"600"}}), maxRetryAfter, true},
+		{"milliseconds capped", apiError(http.StatusServiceUnavailable, "", http.Header{"Retry-After-Ms": {"3600000"}}), maxRetryAfter, true},
+		{"date capped", apiError(http.StatusServiceUnavailable, "", http.Header{"Retry-After": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}), maxRetryAfter, true},
+		{"date in the past", apiError(http.StatusServiceUnavailable, "", http.Header{"Retry-After": {time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}}), 0, true},
+		{"invalid", apiError(http.StatusTooManyRequests, "", http.Header{"Retry-After": {"soon"}}), 0, false},
+		{"negative", apiError(http.StatusTooManyRequests, "", http.Header{"Retry-After": {"-5"}}), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.err)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// scriptedClient returns the scripted errors in order and succeeds once they
+// run out.
+type scriptedClient struct {
+	errs  []error
+	calls int
+}
+
+func (c *scriptedClient) ChatCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return nil, c.errs[c.calls-1]
+	}
+	return &openai.ChatCompletion{ID: "ok"}, nil
+}
+
+func TestRequestWithRetry(t *testing.T) {
+	unavailable := apiError(http.StatusServiceUnavailable, "", nil)
+	limited := apiError(http.StatusTooManyRequests, "", http.Header{"Retry-After": {"3"}})
+	badRequest := apiError(http.StatusBadRequest, "", nil)
+
+	tests := []struct {
+		name       string
+		errs       []error
+		maxRetries int
+		sleepErr   error
+		wantCalls  int
+		wantSleeps []time.Duration
+		wantErr    error
+	}{
+		{
+			name:       "success",
+			maxRetries: 3,
+			wantCalls:  1,
+		},
+		{
+			name:       "recovers after transient errors",
+			errs:       []error{unavailable, unavailable},
+			maxRetries: 3,
+			wantCalls:  3,
+			wantSleeps: []time.Duration{50 * time.Millisecond, 100 * time.Millisecond},
+		},
+		{
+			name:       "honors Retry-After",
+			errs:       []error{limited},
+			maxRetries: 3,
+			wantCalls:  2,
+			wantSleeps: []time.Duration{3 * time.Second},
+		},
+		{
+			name:       "does not retry client errors",
+			errs:       []error{badRequest},
+			maxRetries: 3,
+			wantCalls:  1,
+			wantErr:    badRequest,
+		},
+		{
+			name:       "gives up after max retries",
+			errs:       []error{unavailable, unavailable, unavailable},
+			maxRetries: 2,
+			wantCalls:  3,
+			wantSleeps: []time.Duration{50 * time.Millisecond, 100 * time.Millisecond},
+			wantErr:    unavailable,
+		},
+		{
+			name:       "no retries",
+			errs:       []error{unavailable},
+			maxRetries: 0,
+			wantCalls:  1,
+			wantErr:    unavailable,
+		},
+		{
+			name:       "cancelled while waiting",
+			errs:       []error{unavailable},
+			maxRetries: 3,
+			sleepErr:   context.Canceled,
+			wantCalls:  1,
+			wantSleeps: []time.Duration{50 * time.Millisecond},
+			wantErr:    context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &scriptedClient{errs: tt.errs}
+			cfg := &config.Config{
+				MemoryCapacity:   10,
+				MaxConcurrency:   1,
+				MaxRetries:       tt.maxRetries,
+				RetryBaseDelayMs: 100,
+				RetryMaxDelayMs:  1000,
+			}
+			a := NewAgent(client, nil, nil, tools.NewRegistry(), cfg, nil)
+
+			var sleeps []time.Duration
+			a.sleep = func(ctx context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return tt.sleepErr
+			}
+			a.jitter = func() float64 { return 0 }
+
+			response, err := a.requestWithRetry(context.Background(), openai.ChatCompletionNewParams{}, false)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil || response == nil || response.ID != "ok" {
+				t.Fatalf("requestWithRetry() = %v, %v", response, err)
+			}
+
+			if client.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", client.calls, tt.wantCalls)
+			}
+			if fmt.Sprint(sleeps) != fmt.Sprint(tt.wantSleeps) {
+				t.Errorf("sleeps = %v, want %v", sleeps, tt.wantSleeps)
+			}
+		})
+	}
+}
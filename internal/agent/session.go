@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go/v3"
+
+	"gocopilot/internal/permission"
+	"gocopilot/internal/session"
+	"gocopilot/internal/tools"
+)
+
+const maxSessionTitleRunes = 60
+
+// SetSessionStore enables persisting the conversation after every turn.
+func (a *Agent) SetSessionStore(store *session.Store) {
+	a.sessions = store
+}
+
+// SessionID returns the ID of the current session, or "" before Run starts one.
+func (a *Agent) SessionID() string {
+	if a.session == nil {
+		return ""
+	}
+	return a.session.ID
+}
+
+// NewSession discards the conversation and starts a fresh session.
+func (a *Agent) NewSession() {
+	a.memory.ResetHistory()
+	a.memory.SetSystemMessages()
+	a.permissions.ResetSession()
+	a.journal.Reset()
+
+	if systemMsg := a.systemPrompt(); systemMsg != "" {
+		a.memory.SetSystemMessages(openai.SystemMessage(systemMsg))
+	}
+
+	a.refreshRepoMap(context.Background())
+
+	now := time.Now()
+	a.session = &session.Session{
+		ID:        session.NewID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	a.logger.Debug("Started session %s", a.session.ID)
+}
+
+// ResumeSession restores a stored session into memory. The ID "last" selects
+// the most recently updated session.
+func (a *Agent) ResumeSession(id string) error {
+	if a.sessions == nil {
+		return fmt.Errorf("session storage is not configured")
+	}
+
+	var sess *session.Session
+	var err error
+	if id == "last" {
+		sess, err = a.sessions.Latest()
+	} else {
+		sess, err = a.sessions.Load(id)
+	}
+	if err != nil {
+		return err
+	}
+
+	system, err := decodeMessages(sess.System)
+	if err != nil {
+		return fmt.Errorf("session %s has invalid system messages: %w", sess.ID, err)
+	}
+	history, err := decodeMessages(sess.History)
+	if err != nil {
+		return fmt.Errorf("session %s has invalid history: %w", sess.ID, err)
+	}
+	if err := a.restoreSettings(sess); err != nil {
+		return fmt.Errorf("session %s cannot be resumed: %w", sess.ID, err)
+	}
+
+	a.memory.Restore(system, sess.Summary, history)
+	a.permissions.ResetSession()
+	a.journal.Reset()
+	// The stored system messages are kept; /prompt reload re-reads the files.
+	a.promptFiles = nil
+
+	if sess.Model != "" && sess.Model != a.config.Model {
+		a.logger.Info("Switching model to %s as recorded in session %s", sess.Model, sess.ID)
+		a.config.Model = sess.Model
+		a.updateContextBudget()
+	}
+
+	current := a.toolNames()
+	for _, name := range sess.Tools {
+		if !slices.Contains(current, name) {
+			a.logger.Warn("Tool %s used in session %s is no longer available", name, sess.ID)
+		}
+	}
+
+	a.session = sess
+	a.logger.Info("Resumed session %s with %d messages", sess.ID, len(history))
+	return nil
+}
+
+// restoreSettings applies the tool configuration a session was saved with.
+// Everything is validated before anything changes. A stored permission mode
+// is only applied when it is at least as strict as the current one, so a
+// session file cannot loosen approval. Permission rules and tool timeouts
+// always come from the current configuration; differences are only logged.
+func (a *Agent) restoreSettings(sess *session.Session) error {
+	settings := sess.Settings
+	if settings == nil {
+		// Saved before settings were recorded
+		return nil
+	}
+
+	var bash *tools.ToolDefinition
+	if settings.Shell != "" && settings.Shell != a.config.Shell {
+		def, err := tools.NewBashDefinition(settings.Shell)
+		if err != nil {
+			return err
+		}
+		bash = &def
+	}
+
+	current := a.permissions.Mode()
+	mode := current
+	if settings.PermissionMode != "" {
+		stored, err := permission.ParseMode(settings.PermissionMode)
+		if err != nil {
+			return err
+		}
+		if stored.AtLeastAsStrict(current) {
+			mode = stored
+		} else {
+			a.logger.Warn("Session %s used permission mode %s, keeping the stricter %s", sess.ID, stored, current)
+		}
+	}
+
+	if bash != nil {
+		a.registry.Replace(*bash)
+		a.config.Shell = settings.Shell
+		a.toolConfigs = a.registry.ToolConfigs()
+		a.updateContextBudget()
+		a.logger.Info("Using shell %s as recorded in session %s", settings.Shell, sess.ID)
+	}
+	if mode != current {
+		a.permissions.SetMode(mode)
+		a.logger.Info("Using permission mode %s as recorded in session %s", mode, sess.ID)
+	}
+	if settings.PermissionsFile != "" && settings.PermissionsFile != a.config.PermissionsFile {
+		a.logger.Warn("Session %s used permission rules from %s, keeping the current rules from %s", sess.ID, settings.PermissionsFile, a.config.PermissionsFile)
+	}
+	if settings.ToolTimeout != a.config.ToolTimeout || !maps.Equal(settings.ToolTimeouts, a.config.ToolTimeouts) {
+		a.logger.Warn("Session %s used different tool timeouts, keeping the current configuration", sess.ID)
+	}
+	return nil
+}
+
+// SaveSession writes the current conversation to the session store.
+func (a *Agent) SaveSession() error {
+	if a.sessions == nil {
+		return fmt.Errorf("session storage is not configured")
+	}
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+	if a.session == nil {
+		a.NewSession()
+	}
+
+	system, summary, history := a.memory.Snapshot()
+
+	encodedSystem, err := encodeMessages(system)
+	if err != nil {
+		return err
+	}
+	encodedHistory, err := encodeMessages(history)
+	if err != nil {
+		return err
+	}
+
+	a.session.UpdatedAt = time.Now()
+	a.session.Model = a.config.Model
+	a.session.Tools = a.toolNames()
+	a.session.Settings = &session.Settings{
+		Shell:           a.config.Shell,
+		PermissionMode:  string(a.permissions.Mode()),
+		PermissionsFile: a.config.PermissionsFile,
+		ToolTimeout:     a.config.ToolTimeout,
+		ToolTimeouts:    a.config.ToolTimeouts,
+	}
+	a.session.System = encodedSystem
+	a.session.Summary = summary
+	a.session.History = encodedHistory
+	if a.session.Title == "" {
+		a.session.Title = sessionTitle(history)
+	}
+
+	return a.sessions.Save(a.session)
+}
+
+// autosave persists the session after a turn without interrupting the chat
+// if the disk write fails. Sessions without any conversation are not saved.
+func (a *Agent) autosave() {
+	if a.sessions == nil {
+		return
+	}
+	if _, summary, history := a.memory.Snapshot(); summary == "" && len(history) == 0 {
+		return
+	}
+	if err := a.SaveSession(); err != nil {
+		a.logger.Warn("Failed to save session: %v", err)
+	}
+}
+
+// SaveBeforeExit autosaves the session when the process is about to exit,
+// possibly in the middle of a turn. Unanswered tool calls are closed first so
+// the saved history can be resumed.
+func (a *Agent) SaveBeforeExit() {
+	if added := a.memory.CloseDanglingToolCalls("Error: tool execution interrupted because gocopilot exited"); added > 0 {
+		a.logger.Debug("Closed %d unanswered tool calls before exiting", added)
+	}
+	a.autosave()
+}
+
+func (a *Agent) toolNames() []string {
+	names := make([]string, 0, a.registry.Count())
+	for _, tool := range a.registry.List() {
+		names = append(names, tool.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func encodeMessages(messages []openai.ChatCompletionMessageParamUnion) ([]json.RawMessage, error) {
+	out := make([]json.RawMessage, 0, len(messages))
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode message: %w", err)
+		}
+		out = append(out, data)
+	}
+	return out, nil
+}
+
+func decodeMessages(raw []json.RawMessage) ([]openai.ChatCompletionMessageParamUnion, error) {
+	out := make([]openai.ChatCompletionMessageParamUnion, 0, len(raw))
+	for _, data := range raw {
+		var message openai.ChatCompletionMessageParamUnion
+		if err := json.Unmarshal(data, &message); err != nil {
+			return nil, err
+		}
+		out = append(out, message)
+	}
+	return out, nil
+}
+
+// sessionTitle uses the first user message as a label for session listings.
+func sessionTitle(history []openai.ChatCompletionMessageParamUnion) string {
+	for _, message := range history {
+		info := describeMessage(message)
+		if info.Role != "user" {
+			continue
+		}
+
+		title := strings.Join(strings.Fields(info.Text()), " ")
+		if utf8.RuneCountInString(title) > maxSessionTitleRunes {
+			title = string([]rune(title)[:maxSessionTitleRunes]) + "…"
+		}
+		return title
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+
+	"gocopilot/internal/config"
+	"gocopilot/internal/permission"
+	"gocopilot/internal/session"
+	"gocopilot/internal/tools"
+)
+
+func newSessionTestAgent(t *testing.T, store *session.Store, cfg config.Config) *Agent {
+	t.Helper()
+	registry := tools.NewRegistry()
+	if err := tools.RegisterBuiltinTools(registry, tools.Options{Shell: cfg.Shell}, &NoopLogger{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg.MemoryCapacity = 10
+	cfg.MaxConcurrency = 1
+	cfg.WorkspaceRoot = t.TempDir()
+	a := NewAgent(&scriptedClient{}, nil, nil, registry, &cfg, nil)
+	a.SetSessionStore(store)
+	return a
+}
+
+func TestResumeSessionRestoresSettings(t *testing.T) {
+	dir := t.TempDir()
+	store := session.NewStore(filepath.Join(dir, "sessions"))
+	savedRules := filepath.Join(dir, "saved.yaml")
+	if err := os.WriteFile(savedRules, []byte("bash:\n  allow: [\"rm *\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	currentRules := filepath.Join(dir, "current.yaml")
+	if err := os.WriteFile(currentRules, []byte("bash:\n  deny: [\"rm *\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := newSessionTestAgent(t, store, config.Config{
+		Shell:           "sh",
+		PermissionMode:  "ask",
+		PermissionsFile: savedRules,
+		ToolTimeout:     5,
+		ToolTimeouts:    map[string]int{"bash": 7},
+	})
+	saved.NewSession()
+	saved.memory.Append(openai.UserMessage("hello"))
+	if err := saved.SaveSession(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newSessionTestAgent(t, store, config.Config{
+		Shell:           "bash",
+		PermissionMode:  "ask-mutating",
+		PermissionsFile: currentRules,
+		ToolTimeout:     60,
+	})
+	rules, err := permission.LoadRules(currentRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed.permissions.SetRules(rules)
+	if err := resumed.ResumeSession(saved.SessionID()); err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.config.Shell != "sh" {
+		t.Errorf("shell = %q, want sh", resumed.config.Shell)
+	}
+	if bash, _ := resumed.registry.Get("bash"); bash.Description == tools.BashDefinition.Description {
+		t.Errorf("bash tool was not reconfigured for sh")
+	}
+	if mode := resumed.permissions.Mode(); mode != permission.ModeAsk {
+		t.Errorf("mode = %s, want ask", mode)
+	}
+
+	// Rules and timeouts stay as currently configured
+	if resumed.config.PermissionsFile != currentRules {
+		t.Errorf("permissions file = %q, want %q", resumed.config.PermissionsFile, currentRules)
+	}
+	decision := resumed.permissions.Check(context.Background(), permission.Request{Tool: "bash", Command: "rm -rf build"})
+	if decision.Allowed || decision.Source != "rule" {
+		t.Errorf("rm is not denied by the current rules: %+v", decision)
+	}
+	if got := resumed.executor.timeoutFor("bash"); got.Seconds() == 7 {
+		t.Errorf("bash timeout = %s, the stored override was applied", got)
+	}
+	if got := resumed.executor.timeoutFor("read_file"); got.Seconds() != 60 {
+		t.Errorf("read_file timeout = %s, want 60s", got)
+	}
+}
+
+func TestResumeSessionKeepsStricterPermissionMode(t *testing.T) {
+	store := session.NewStore(t.TempDir())
+
+	saved := newSessionTestAgent(t, store, config.Config{PermissionMode: "auto"})
+	saved.NewSession()
+	saved.memory.Append(openai.UserMessage("hello"))
+	if err := saved.SaveSession(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newSessionTestAgent(t, store, config.Config{PermissionMode: "deny"})
+	if err := resumed.ResumeSession(saved.SessionID()); err != nil {
+		t.Fatal(err)
+	}
+	if mode := resumed.permissions.Mode(); mode != permission.ModeDeny {
+		t.Errorf("mode = %s, want deny", mode)
+	}
+}
+
+func TestResumeSessionRejectsInvalidSettings(t *testing.T) {
+	store := session.NewStore(t.TempDir())
+
+	saved := newSessionTestAgent(t, store, config.Config{PermissionMode: "ask"})
+	saved.NewSession()
+	saved.memory.Append(openai.UserMessage("hello"))
+	if err := saved.SaveSession(); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := store.Load(saved.SessionID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Settings.Shell = "fish"
+	if err := store.Save(sess); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newSessionTestAgent(t, store, config.Config{PermissionMode: "ask"})
+	if err := resumed.ResumeSession(saved.SessionID()); err == nil {
+		t.Fatal("expected an error for an unsupported shell")
+	}
+	if resumed.memory.MessageCount() != 0 {
+		t.Errorf("history was restored despite the error")
+	}
+}
+
+func TestSaveBeforeExitClosesUnansweredToolCalls(t *testing.T) {
+	store := session.NewStore(t.TempDir())
+	a := newSessionTestAgent(t, store, config.Config{PermissionMode: "ask"})
+	a.NewSession()
+	a.memory.Append(openai.UserMessage("read a.go"))
+	a.memory.Append(assistantWithCalls(t, "call_1"))
+
+	a.SaveBeforeExit()
+
+	sess, err := store.Load(a.SessionID())
+	if err != nil {
+		t.Fatalf("session was not saved: %v", err)
+	}
+	resumed := newSessionTestAgent(t, store, config.Config{PermissionMode: "ask"})
+	if err := resumed.ResumeSession(sess.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, _, history := resumed.memory.Snapshot()
+	if len(history) != 3 || describeMessage(history[2]).ToolCallID != "call_1" {
+		t.Errorf("saved history has %d messages, want the tool call answered", len(history))
+	}
+}
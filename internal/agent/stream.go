@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// streamAccumulator folds streamed chunks back into a complete ChatCompletion.
+//
+// The SDK's own ChatCompletionAccumulator does not populate the raw JSON
+// metadata of the resulting message, which ChatCompletionMessageToolCallUnion.AsAny
+// relies on. We therefore accumulate into plain structs and round-trip through
+// JSON so the completion behaves exactly like a non-streamed response.
+type streamAccumulator struct {
+	id      string
+	created int64
+	model   string
+	choices []*streamChoice
+	usage   *openai.CompletionUsage
+}
+
+type streamChoice struct {
+	index        int64
+	finishReason string
+	content      string
+	refusal      string
+	toolCalls    []*streamToolCall
+	byIndex      map[int64]*streamToolCall
+}
+
+type streamToolCall struct {
+	id        string
+	callType  string
+	name      string
+	arguments string
+}
+
+// maxStreamChoices bounds the choice index a chunk may use, so a malformed
+// chunk cannot make the accumulator allocate without limit.
+const maxStreamChoices = 128
+
+func newStreamAccumulator() *streamAccumulator {
+	return &streamAccumulator{}
+}
+
+// AddChunk merges a single chunk into the accumulated completion. It fails on
+// chunks with a choice or tool call index that cannot be valid.
+func (s *streamAccumulator) AddChunk(chunk openai.ChatCompletionChunk) error {
+	if s.id == "" {
+		s.id = chunk.ID
+	}
+	if s.created == 0 {
+		s.created = chunk.Created
+	}
+	if s.model == "" {
+		s.model = chunk.Model
+	}
+	if chunk.JSON.Usage.Valid() {
+		usage := chunk.Usage
+		s.usage = &usage
+	}
+
+	for _, delta := range chunk.Choices {
+		if delta.Index < 0 || delta.Index >= maxStreamChoices {
+			return fmt.Errorf("stream chunk has invalid choice index %d", delta.Index)
+		}
+		choice := s.choice(delta.Index)
+		if delta.FinishReason != "" {
+			choice.finishReason = delta.FinishReason
+		}
+		choice.content += delta.Delta.Content
+		choice.refusal += delta.Delta.Refusal
+
+		for _, toolDelta := range delta.Delta.ToolCalls {
+			if toolDelta.Index < 0 {
+				return fmt.Errorf("stream chunk has invalid tool call index %d", toolDelta.Index)
+			}
+			call := choice.toolCall(toolDelta.Index, toolDelta.ID)
+			if toolDelta.ID != "" {
+				call.id = toolDelta.ID
+			}
+			if toolDelta.Type != "" {
+				call.callType = toolDelta.Type
+			}
+			// The name arrives whole with the first delta of a call; some
+			// gateways repeat it on later deltas, so only arguments are
+			// concatenated.
+			if call.name == "" {
+				call.name = toolDelta.Function.Name
+			}
+			call.arguments += toolDelta.Function.Arguments
+		}
+	}
+	return nil
+}
+
+// Completion returns the accumulated response as a ChatCompletion.
+func (s *streamAccumulator) Completion() (*openai.ChatCompletion, error) {
+	if len(s.choices) == 0 {
+		return nil, fmt.Errorf("stream ended without any choices")
+	}
+
+	type toolCallFunction struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	}
+	type toolCall struct {
+		ID       string           `json:"id"`
+		Type     string           `json:"type"`
+		Function toolCallFunction `json:"function"`
+	}
+	type message struct {
+		Role      string     `json:"role"`
+		Content   string     `json:"content"`
+		Refusal   string     `json:"refusal,omitempty"`
+		ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	}
+	type choice struct {
+		Index        int64   `json:"index"`
+		FinishReason string  `json:"finish_reason"`
+		Message      message `json:"message"`
+	}
+	type completion struct {
+		ID      string                  `json:"id"`
+		Object  string                  `json:"object"`
+		Created int64                   `json:"created"`
+		Model   string                  `json:"model"`
+		Choices []choice                `json:"choices"`
+		Usage   *openai.CompletionUsage `json:"usage,omitempty"`
+	}
+
+	out := completion{
+		ID:      s.id,
+		Object:  "chat.completion",
+		Created: s.created,
+		Model:   s.model,
+		Usage:   s.usage,
+	}
+
+	for _, c := range s.choices {
+		if c == nil {
+			continue
+		}
+
+		msg := message{
+			Role:    "assistant",
+			Content: c.content,
+			Refusal: c.refusal,
+		}
+		for _, call := range c.toolCalls {
+			callType := call.callType
+			if callType == "" {
+				callType = "function"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, toolCall{
+				ID:   call.id,
+				Type: callType,
+				Function: toolCallFunction{
+					Name:      call.name,
+					Arguments: call.arguments,
+				},
+			})
+		}
+
+		out.Choices = append(out.Choices, choice{
+			Index:        c.index,
+			FinishReason: c.finishReason,
+			Message:      msg,
+		})
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode streamed completion: %w", err)
+	}
+
+	var result openai.ChatCompletion
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode streamed completion: %w", err)
+	}
+
+	return &result, nil
+}
+
+func (s *streamAccumulator) choice(index int64) *streamChoice {
+	for int64(len(s.choices)) <= index {
+		s.choices = append(s.choices, nil)
+	}
+	if s.choices[index] == nil {
+		s.choices[index] = &streamChoice{index: index}
+	}
+	return s.choices[index]
+}
+
+// toolCall returns the call addressed by a delta. Providers that do not
+// number parallel calls send every delta with the same index, so a new ID
+// arriving on an occupied index starts a new call.
+func (c *streamChoice) toolCall(index int64, id string) *streamToolCall {
+	if c.byIndex == nil {
+		c.byIndex = make(map[int64]*streamToolCall)
+	}
+
+	call, ok := c.byIndex[index]
+	if !ok || (id != "" && call.id != "" && call.id != id) {
+		call = &streamToolCall{}
+		c.toolCalls = append(c.toolCalls, call)
+		c.byIndex[index] = call
+	}
+	return call
+}
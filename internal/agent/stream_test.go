@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// toolChunk returns a chunk carrying one tool call delta; empty fields are
+// left out as providers do.
+func toolChunk(t *testing.T, index int, id, name, arguments string) openai.ChatCompletionChunk {
+	t.Helper()
+	call := map[string]any{"index": index, "function": map[string]any{"arguments": arguments}}
+	if id != "" {
+		call["id"] = id
+		call["type"] = "function"
+	}
+	if name != "" {
+		call["function"].(map[string]any)["name"] = name
+	}
+	data, err := json.Marshal(map[string]any{
+		"id": "chunk", "object": "chat.completion.chunk", "created": 1, "model": "test",
+		"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"tool_calls": []any{call}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunkFromJSON(t, string(data))
+}
+
+func chunkFromJSON(t *testing.T, data string) openai.ChatCompletionChunk {
+	t.Helper()
+	var chunk openai.ChatCompletionChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		t.Fatal(err)
+	}
+	return chunk
+}
+
+// accumulatedCalls returns the tool calls of the completion as
+// "id name arguments" strings.
+func accumulatedCalls(t *testing.T, chunks []openai.ChatCompletionChunk) []string {
+	t.Helper()
+	acc := newStreamAccumulator()
+	for _, chunk := range chunks {
+		if err := acc.AddChunk(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	completion, err := acc.Completion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for _, call := range completion.Choices[0].Message.ToolCalls {
+		calls = append(calls, fmt.Sprintf("%s %s %s", call.ID, call.Function.Name, call.Function.Arguments))
+	}
+	return calls
+}
+
+func TestStreamAccumulatorToolCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks func(t *testing.T) []openai.ChatCompletionChunk
+		want   []string
+	}{
+		{
+			name: "arguments split across chunks",
+			chunks: func(t *testing.T) []openai.ChatCompletionChunk {
+				return []openai.ChatCompletionChunk{
+					toolChunk(t, 0, "call_1", "read_file", `{"pa`),
+					toolChunk(t, 0, "", "", `th":"a`),
+					toolChunk(t, 0, "", "", `.go"}`),
+				}
+			},
+			want: []string{`call_1 read_file {"path":"a.go"}`},
+		},
+		{
+			name: "parallel calls on different indexes",
+			chunks: func(t *testing.T) []openai.ChatCompletionChunk {
+				return []openai.ChatCompletionChunk{
+					toolChunk(t, 0, "call_1", "read_file", `{"path":`),
+					toolChunk(t, 1, "call_2", "list_files", `{"path":`),
+					toolChunk(t, 0, "", "", `"a"}`),
+					toolChunk(t, 1, "", "", `"b"}`),
+				}
+			},
+			want: []string{`call_1 read_file {"path":"a"}`, `call_2 list_files {"path":"b"}`},
+		},
+		{
+			name: "index reused with a new id",
+			chunks: func(t *testing.T) []openai.ChatCompletionChunk {
+				return []openai.ChatCompletionChunk{
+					toolChunk(t, 0, "call_1", "read_file", `{"path":"a"}`),
+					toolChunk(t, 0, "call_2", "read_file", `{"path":`),
+					toolChunk(t, 0, "", "", `"b"}`),
+				}
+			},
+			want: []string{`call_1 read_file {"path":"a"}`, `call_2 read_file {"path":"b"}`},
+		},
+		{
+			name: "name repeated on later deltas",
+			chunks: func(t *testing.T) []openai.ChatCompletionChunk {
+				return []openai.ChatCompletionChunk{
+					toolChunk(t, 0, "call_1", "bash", `{"command":`),
+					toolChunk(t, 0, "call_1", "bash", `"ls"}`),
+				}
+			},
+			want: []string{`call_1 bash {"command":"ls"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		got := accumulatedCalls(t, tt.chunks(t))
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStreamAccumulatorRejectsInvalidIndexes(t *testing.T) {
+	chunks := []string{
+		`{"id":"c","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":-1,"delta":{"content":"x"}}]}`,
+		`{"id":"c","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":1000000000,"delta":{"content":"x"}}]}`,
+		`{"id":"c","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"tool_calls":[{"index":-1,"function":{"arguments":"{}"}}]}}]}`,
+	}
+	for _, data := range chunks {
+		if err := newStreamAccumulator().AddChunk(chunkFromJSON(t, data)); err == nil {
+			t.Errorf("no error for %s", data)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// DefaultContextWindow is used for models missing from the built-in table.
+// It is deliberately conservative; set CONTEXT_WINDOW for larger models.
+const DefaultContextWindow = 8192
+
+// messageTokenOverhead approximates the per-message framing tokens (role,
+// separators) the API adds on top of the content.
+const messageTokenOverhead = 4
+
+// Tokenizer estimates how many tokens a piece of text occupies.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// HeuristicTokenizer estimates tokens locally without a vocabulary: roughly
+// four characters per token for Latin text and one token per CJK character.
+type HeuristicTokenizer struct{}
+
+func (HeuristicTokenizer) CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	wide := 0
+	narrow := 0
+	for _, r := range text {
+		if r >= utf8.RuneSelf && (unicode.Is(unicode.Han, r) ||
+			unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) ||
+			unicode.Is(unicode.Hangul, r)) {
+			wide++
+		} else {
+			narrow++
+		}
+	}
+
+	return wide + (narrow+3)/4
+}
+
+// modelContextWindows lists known context sizes by model name prefix. The
+// longest matching prefix wins.
+var modelContextWindows = map[string]int{
+	"gpt-3.5-turbo": 16385,
+	"gpt-4":         8192,
+	"gpt-4-32k":     32768,
+	"gpt-4-turbo":   128000,
+	"gpt-4o":        128000,
+	"gpt-4.1":       1047576,
+	"gpt-4.5":       128000,
+	"gpt-5":         400000,
+	"o1":            200000,
+	"o1-mini":       128000,
+	"o3":            200000,
+	"o4-mini":       200000,
+	"deepseek":      65536,
+	"claude":        200000,
+}
+
+// ContextWindowForModel returns the context window of a model. Overrides are
+// matched by exact name first and take precedence over the built-in table.
+func ContextWindowForModel(model string, overrides map[string]int) int {
+	if window, ok := overrides[model]; ok && window > 0 {
+		return window
+	}
+
+	best := ""
+	for prefix := range modelContextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return DefaultContextWindow
+	}
+	return modelContextWindows[best]
+}
+
+func countMessageTokens(tokenizer Tokenizer, info messageInfo) int {
+	tokens := messageTokenOverhead + tokenizer.CountTokens(info.Text())
+	for _, call := range info.ToolCalls {
+		tokens += tokenizer.CountTokens(call.Function.Name)
+		tokens += tokenizer.CountTokens(call.Function.Arguments)
+	}
+	return tokens
+}
+
+func countToolTokens(tokenizer Tokenizer, toolConfigs []openai.ChatCompletionToolUnionParam) int {
+	if len(toolConfigs) == 0 {
+		return 0
+	}
+
+	data, err := json.Marshal(toolConfigs)
+	if err != nil {
+		return 0
+	}
+	return tokenizer.CountTokens(string(data))
+}
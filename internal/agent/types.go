@@ -2,11 +2,19 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/openai/openai-go/v3"
 )
 
+// ErrMaxStepsExceeded is returned when a turn runs out of tool rounds or
+// reasoning steps before the model produces a final answer.
+var ErrMaxStepsExceeded = errors.New("maximum steps exceeded")
+
+// ErrTurnCancelled is returned when a turn is stopped with Agent.CancelTurn.
+var ErrTurnCancelled = errors.New("turn cancelled")
+
 type Logger interface {
 	Debug(format string, args ...interface{})
 	Info(format string, args ...interface{})
@@ -18,6 +26,22 @@ type InferenceClient interface {
 	ChatCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error)
 }
 
+// StreamingInferenceClient is implemented by clients that can deliver a
+// completion incrementally instead of waiting for the full response.
+type StreamingInferenceClient interface {
+	InferenceClient
+	ChatCompletionStream(ctx context.Context, params openai.ChatCompletionNewParams) ChatCompletionStream
+}
+
+// ChatCompletionStream matches the SDK's ssestream.Stream so it can be
+// returned directly by client wrappers.
+type ChatCompletionStream interface {
+	Next() bool
+	Current() openai.ChatCompletionChunk
+	Err() error
+	Close() error
+}
+
 type UserInputProvider interface {
 	GetUserMessage() (string, bool)
 }
@@ -29,20 +53,64 @@ type OutputHandler interface {
 	PrintToolError(error string)
 }
 
-type DefaultOutputHandler struct{}
+// StreamingOutputHandler receives assistant content as it is generated.
+type StreamingOutputHandler interface {
+	OutputHandler
+	PrintAssistantDelta(delta string)
+	EndAssistantMessage()
+}
+
+// NoticeOutputHandler receives status lines that are not part of the
+// conversation, such as reasoning step headers and compaction notices.
+type NoticeOutputHandler interface {
+	PrintNotice(text string)
+}
+
+// ToolOutputHandler receives output tools produce while they are running,
+// before their result is returned to the model.
+type ToolOutputHandler interface {
+	PrintToolOutput(text string)
+}
+
+type DefaultOutputHandler struct {
+	streaming bool
+}
 
 func (d *DefaultOutputHandler) PrintAssistantMessage(content string) {
 	fmt.Printf("\u001b[1;33m🤖 Gocopilot\u001b[0m: %s\n", content)
 }
 
+func (d *DefaultOutputHandler) PrintAssistantDelta(delta string) {
+	if !d.streaming {
+		fmt.Print("\u001b[1;33m🤖 Gocopilot\u001b[0m: ")
+		d.streaming = true
+	}
+	fmt.Print(delta)
+}
+
+func (d *DefaultOutputHandler) EndAssistantMessage() {
+	if d.streaming {
+		fmt.Println()
+		d.streaming = false
+	}
+}
+
+func (d *DefaultOutputHandler) PrintNotice(text string) {
+	fmt.Println(text)
+}
+
 func (d *DefaultOutputHandler) PrintToolCall(toolName, arguments string) {
 	fmt.Printf("\u001b[36m🔧 Tool\u001b[0m: %s(%s)\n", toolName, arguments)
 }
 
+func (d *DefaultOutputHandler) PrintToolOutput(text string) {
+	fmt.Printf("\u001b[2m%s\u001b[0m", text)
+}
+
 func (d *DefaultOutputHandler) PrintToolResult(output string) {
 	fmt.Printf("\u001b[32m✅ Result\u001b[0m: %s\n", output)
 }
 
 func (d *DefaultOutputHandler) PrintToolError(error string) {
 	fmt.Printf("\u001b[31m❌ Error\u001b[0m: %s\n", error)
-}
\ No newline at end of file
+}
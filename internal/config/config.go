@@ -2,39 +2,112 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-    OpenAIAPIKey    string
-    OpenAIBaseURL   string
-    Model           string
-    MaxTokens       int
-    MemoryCapacity  int
-    Verbose         bool
-    MaxConcurrency  int
-    RequestTimeout  int
-    ReasoningEnabled bool
-    ReasoningMaxSteps int
+    OpenAIAPIKey        string
+    OpenAIBaseURL       string
+    Model               string
+    MaxTokens           int
+    MemoryCapacity      int
+    Verbose             bool
+    MaxConcurrency      int
+    RequestTimeout      int
+    ReasoningEnabled    bool
+    ReasoningMaxSteps   int
+	MaxToolRounds       int
+	StreamEnabled       bool
+	MaxRetries          int
+	RetryBaseDelayMs    int
+	RetryMaxDelayMs     int
+	ContextWindow       int
+	ModelContextWindows map[string]int
+	CompactionEnabled   bool
+	SessionDir          string
+	WorkspaceRoot       string
+	ReadOnlyRoots       []string
+	ToolTimeout         int
+	ToolTimeouts        map[string]int
+	Shell               string
+	PermissionMode      string
+	PermissionsFile     string
+	AuditLog            string
+	RepoMapTokens       int
+	SystemMessage       string
+	InstructionFiles    bool
+	UserInstructions    string
 }
 
 func Load() *Config {
     cfg := &Config{
-        OpenAIAPIKey:   os.Getenv("OPENAI_API_KEY"),
-        OpenAIBaseURL:  os.Getenv("OPENAI_API_BASE_URL"),
-        Model:          getEnvWithDefault("MODEL", "gpt-4"),
-        MaxTokens:      getEnvIntWithDefault("MAX_TOKENS", 1024),
-        MemoryCapacity: getEnvIntWithDefault("MEMORY_CAPACITY", 40),
-        Verbose:        getEnvBoolWithDefault("VERBOSE", false),
-        MaxConcurrency: getEnvIntWithDefault("MAX_CONCURRENCY", 5),
-        RequestTimeout: getEnvIntWithDefault("REQUEST_TIMEOUT", 30),
-        ReasoningEnabled: getEnvBoolWithDefault("REASONING_ENABLED", false),
-        ReasoningMaxSteps: getEnvIntWithDefault("REASONING_MAX_STEPS", 10),
+        OpenAIAPIKey:        os.Getenv("OPENAI_API_KEY"),
+        OpenAIBaseURL:       os.Getenv("OPENAI_API_BASE_URL"),
+        Model:               getEnvWithDefault("MODEL", "gpt-4"),
+        MaxTokens:           getEnvIntWithDefault("MAX_TOKENS", 1024),
+        MemoryCapacity:      getEnvIntWithDefault("MEMORY_CAPACITY", 40),
+        Verbose:             getEnvBoolWithDefault("VERBOSE", false),
+        MaxConcurrency:      getEnvIntWithDefault("MAX_CONCURRENCY", 5),
+        RequestTimeout:      getEnvIntWithDefault("REQUEST_TIMEOUT", 30),
+        ReasoningEnabled:    getEnvBoolWithDefault("REASONING_ENABLED", false),
+        ReasoningMaxSteps:   getEnvIntWithDefault("REASONING_MAX_STEPS", 10),
+		MaxToolRounds:       getEnvIntWithDefault("MAX_TOOL_ROUNDS", 50),
+		StreamEnabled:       getEnvBoolWithDefault("STREAM", true),
+		MaxRetries:          getEnvIntWithDefault("MAX_RETRIES", 3),
+		RetryBaseDelayMs:    getEnvIntWithDefault("RETRY_BASE_DELAY_MS", 500),
+		RetryMaxDelayMs:     getEnvIntWithDefault("RETRY_MAX_DELAY_MS", 20000),
+		ContextWindow:       getEnvIntWithDefault("CONTEXT_WINDOW", 0),
+		ModelContextWindows: getEnvIntMap("MODEL_CONTEXT_WINDOWS"),
+		CompactionEnabled:   getEnvBoolWithDefault("MEMORY_COMPACTION", false),
+		SessionDir:          getEnvWithDefault("SESSION_DIR", defaultSessionDir()),
+		WorkspaceRoot:       getEnvWithDefault("WORKSPACE_ROOT", defaultWorkspaceRoot()),
+		ReadOnlyRoots:       filepath.SplitList(os.Getenv("READ_ONLY_ROOTS")),
+		ToolTimeout:         getEnvIntWithDefault("TOOL_TIMEOUT", 60),
+		ToolTimeouts:        getEnvIntMap("TOOL_TIMEOUTS"),
+		Shell:               getEnvWithDefault("TOOL_SHELL", "bash"),
+		PermissionMode:      getEnvWithDefault("PERMISSION_MODE", "ask-mutating"),
+		PermissionsFile:     os.Getenv("PERMISSIONS_FILE"),
+		AuditLog:            os.Getenv("AUDIT_LOG"),
+		RepoMapTokens:       getEnvIntWithDefault("REPO_MAP_TOKENS", 1500),
+		SystemMessage:       os.Getenv("SYSTEM_MESSAGE"),
+		InstructionFiles:    getEnvBoolWithDefault("INSTRUCTION_FILES", true),
+		UserInstructions:    getEnvWithDefault("USER_INSTRUCTIONS_FILE", defaultUserInstructions()),
     }
 
     return cfg
 }
 
+// defaultSessionDir places sessions under ~/.gocopilot/sessions, falling back
+// to the working directory when the home directory is unknown.
+func defaultSessionDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".gocopilot", "sessions")
+	}
+	return filepath.Join(home, ".gocopilot", "sessions")
+}
+
+// defaultUserInstructions is the user-level instruction file,
+// ~/.gocopilot/GOCOPILOT.md.
+func defaultUserInstructions() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gocopilot", "GOCOPILOT.md")
+}
+
+// defaultWorkspaceRoot is the directory gocopilot was started in.
+func defaultWorkspaceRoot() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return dir
+}
+
 func getEnvWithDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -59,3 +132,19 @@ func getEnvBoolWithDefault(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvIntMap parses a comma separated list of name=value pairs, e.g.
+// "gpt-4o=128000,local-llama=32768". Malformed entries are skipped.
+func getEnvIntMap(key string) map[string]int {
+	result := make(map[string]int)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if intValue, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			result[strings.TrimSpace(name)] = intValue
+		}
+	}
+	return result
+}
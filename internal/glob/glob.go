@@ -0,0 +1,78 @@
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ToRegexp translates a slash separated glob into an unanchored regular
+// expression: * and ? stay within one path element, ** spans any number of
+// them, [...] and [!...] match one character of a class and a backslash
+// quotes the next character.
+func ToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*' && strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			class, n := classToRegexp(glob[i:])
+			if n == 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString(class)
+			i += n - 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Compile returns a regular expression matching whole paths against glob.
+func Compile(glob string) (*regexp.Regexp, error) {
+	return regexp.Compile("^" + ToRegexp(glob) + "$")
+}
+
+// classToRegexp translates the character class at the start of glob and
+// returns its length, or 0 if the class is not terminated. A ] right after
+// the opening bracket or negation is part of the class, and a negated class
+// does not match a slash.
+func classToRegexp(glob string) (string, int) {
+	i := 1
+	negate := false
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		negate = true
+		i++
+	}
+
+	var b strings.Builder
+	for start := i; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == ']' && i > start:
+			if negate {
+				return "[^/" + b.String() + "]", i + 1
+			}
+			return "[" + b.String() + "]", i + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return "", 0
+}
@@ -0,0 +1,47 @@
+package glob
+
+import "testing"
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"?.go", "a.go", true},
+		{"?.go", "/.go", false},
+		{"**/*.pem", "key.pem", true},
+		{"**/*.pem", "a/b/key.pem", true},
+		{"internal/**", "internal/a/b.go", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"[abc].txt", "b.txt", true},
+		{"[abc].txt", "d.txt", false},
+		{"[a-c].txt", "b.txt", true},
+		{"[!abc].txt", "d.txt", true},
+		{"[!abc].txt", "a.txt", false},
+		{"[^abc].txt", "d.txt", true},
+		{"x[!a]y", "x/y", false},
+		{"[]].txt", "].txt", true},
+		{"[!]].txt", "a.txt", true},
+		{"[.].txt", "..txt", true},
+		{"[.].txt", "a.txt", false},
+		{"[a.txt", "[a.txt", true},
+		{`\*.txt`, "*.txt", true},
+		{`\*.txt`, "a.txt", false},
+		{"a+b(c).txt", "a+b(c).txt", true},
+	}
+
+	for _, tt := range tests {
+		re, err := Compile(tt.glob)
+		if err != nil {
+			t.Errorf("%q: %v", tt.glob, err)
+			continue
+		}
+		if got := re.MatchString(tt.path); got != tt.match {
+			t.Errorf("%q matching %q = %v, want %v", tt.glob, tt.path, got, tt.match)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package instructions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// FileName is the name of project instruction files.
+const FileName = "GOCOPILOT.md"
+
+// maxFileBytes bounds how much of a single instruction file is loaded.
+const maxFileBytes = 64 * 1024
+
+// File is an instruction file that was found and read.
+type File struct {
+	Path      string
+	Content   string
+	Truncated bool
+}
+
+// Discover returns the instruction files that apply to the workspace, lowest
+// precedence first: the user-level file, then GOCOPILOT.md in each parent
+// directory of the workspace from the filesystem root down, and finally the
+// one in the workspace root itself. Missing and empty files are skipped.
+func Discover(workspaceRoot, userFile string) ([]File, error) {
+	var paths []string
+	if userFile != "" {
+		paths = append(paths, userFile)
+	}
+
+	root, err := filepath.Abs(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for dir := root; ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		paths = append(paths, filepath.Join(dirs[i], FileName))
+	}
+
+	var files []File
+	var errs []error
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		file, err := readFile(path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if strings.TrimSpace(file.Content) != "" {
+			files = append(files, file)
+		}
+	}
+	return files, errors.Join(errs...)
+}
+
+func readFile(path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return File{}, err
+	}
+	if info.IsDir() {
+		return File{}, fmt.Errorf("%s is a directory", path)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, maxFileBytes+1))
+	if err != nil {
+		return File{}, err
+	}
+
+	file := File{Path: path}
+	if len(data) > maxFileBytes {
+		data = data[:truncationPoint(data)]
+		file.Truncated = true
+	}
+	file.Content = strings.TrimSpace(string(data))
+	return file, nil
+}
+
+// truncationPoint returns where to cut data that exceeds maxFileBytes: after
+// the last newline if that keeps most of it, otherwise at the last rune
+// boundary, so no UTF-8 character is split.
+func truncationPoint(data []byte) int {
+	if i := bytes.LastIndexByte(data[:maxFileBytes], '\n'); i >= maxFileBytes/2 {
+		return i + 1
+	}
+	cut := maxFileBytes
+	for cut > 0 && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// Compose merges the instruction files and the configured system message
+// into a single system prompt. Later parts take precedence, so the system
+// message comes last. It returns "" when there is nothing to say.
+func Compose(files []File, systemMessage string) string {
+	systemMessage = strings.TrimSpace(systemMessage)
+	if len(files) == 0 {
+		return systemMessage
+	}
+
+	var b strings.Builder
+	b.WriteString("The following instructions come from the user and the project. When they conflict, later instructions take precedence over earlier ones.")
+	for _, file := range files {
+		fmt.Fprintf(&b, "\n\n# Instructions from %s\n\n%s", file.Path, file.Content)
+		if file.Truncated {
+			fmt.Fprintf(&b, "\n\n(truncated after %d bytes)", maxFileBytes)
+		}
+	}
+	if systemMessage != "" {
+		b.WriteString("\n\n# Instructions from SYSTEM_MESSAGE\n\n")
+		b.WriteString(systemMessage)
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package instructions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReadFileTruncatesAtBoundaries(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		suffix  string
+	}{
+		// a long single line of three-byte runes is cut between runes
+		{"runes", strings.Repeat("界", maxFileBytes), "界"},
+		// with lines, the last complete line is kept
+		{"lines", strings.Repeat("规则：保持简洁。\n", maxFileBytes/10), "规则：保持简洁。"},
+	}
+
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), FileName)
+		if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Truncated || len(got.Content) > maxFileBytes {
+			t.Errorf("%s: truncated = %v, %d bytes", tt.name, got.Truncated, len(got.Content))
+		}
+		if !utf8.ValidString(got.Content) {
+			t.Errorf("%s: content is not valid UTF-8", tt.name)
+		}
+		if !strings.HasSuffix(got.Content, tt.suffix) {
+			t.Errorf("%s: content ends with %q", tt.name, got.Content[max(0, len(got.Content)-20):])
+		}
+	}
+}
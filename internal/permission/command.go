@@ -0,0 +1,462 @@
+package permission
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// maxCommandDepth bounds how deeply substitutions and "sh -c" scripts are
+// parsed, so a crafted command cannot make the parser recurse forever.
+const maxCommandDepth = 8
+
+// parsedCommand is a shell command line broken into simple commands.
+type parsedCommand struct {
+	// commands are all simple commands on the line, including those inside
+	// command substitutions and scripts passed to "sh -c" or eval.
+	commands [][]string
+	// compound is set when the line chains commands, redirects or uses
+	// substitutions, so it does more than run a single command.
+	compound bool
+	// invalid explains why the line could not be parsed reliably, e.g. an
+	// unterminated quote or a command name that is only known at run time.
+	invalid string
+}
+
+// shellWrappers run the command given in their arguments. The value lists
+// their options that take an argument.
+var shellWrappers = map[string][]string{
+	"sudo":    {"-u", "-g", "-C", "-D", "-h", "-p", "-r", "-t", "-U"},
+	"doas":    {"-u", "-C"},
+	"env":     {"-u", "-C", "-S"},
+	"command": nil,
+	"builtin": nil,
+	"exec":    {"-a"},
+	"nohup":   nil,
+	"time":    nil,
+	"nice":    {"-n"},
+	"ionice":  {"-c", "-n", "-p"},
+	"stdbuf":  {"-i", "-o", "-e"},
+	"timeout": {"-k", "-s"},
+	"xargs":   {"-a", "-d", "-E", "-I", "-L", "-n", "-P", "-s"},
+	// multi-call binaries take the applet to run as their first argument
+	"busybox":   nil,
+	"toybox":    nil,
+	"coreutils": nil,
+}
+
+// shells are interpreters whose -c argument is itself a command line.
+var shells = map[string]bool{"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true, "fish": true, "nu": true}
+
+// reservedWords may precede the name of a simple command.
+var reservedWords = map[string]bool{
+	"!": true, "{": true, "}": true, "if": true, "then": true, "else": true,
+	"elif": true, "fi": true, "do": true, "done": true, "while": true, "until": true,
+}
+
+// parseCommand parses a command line the way a POSIX shell tokenizes it,
+// closely enough to find every command it runs.
+func parseCommand(line string) parsedCommand {
+	var parsed parsedCommand
+	parseInto(&parsed, line, 0)
+	return parsed
+}
+
+func parseInto(parsed *parsedCommand, line string, depth int) {
+	if depth > maxCommandDepth {
+		parsed.invalid = "commands are nested too deeply"
+		return
+	}
+
+	p := commandParser{line: line, parsed: parsed, depth: depth}
+	p.parse()
+	if p.invalid != "" && parsed.invalid == "" {
+		parsed.invalid = p.invalid
+	}
+}
+
+type commandParser struct {
+	line   string
+	pos    int
+	depth  int
+	parsed *parsedCommand
+
+	words   []string
+	word    strings.Builder
+	inWord  bool
+	dynamic bool // the current word contains an expansion
+	// dynamicName is set when the command name of the current simple
+	// command is only known at run time
+	dynamicName bool
+	// skipWord drops the next word, the target of a redirection
+	skipWord bool
+	invalid  string
+}
+
+func (p *commandParser) parse() {
+	for p.pos < len(p.line) && p.invalid == "" {
+		c := p.line[p.pos]
+		switch {
+		case c == ' ' || c == '\t':
+			p.endWord()
+			p.pos++
+		case c == '\n' || c == ';' || c == '&' || c == '|':
+			if c == '&' && p.pos+1 < len(p.line) && p.line[p.pos+1] == '>' {
+				// &> redirects stdout and stderr
+				p.redirect()
+				continue
+			}
+			p.endCommand()
+			if c != '\n' {
+				p.parsed.compound = true
+			}
+			p.pos++
+		case c == '(' || c == ')':
+			p.endCommand()
+			p.parsed.compound = true
+			p.pos++
+		case c == '>' || c == '<':
+			if p.pos+1 < len(p.line) && p.line[p.pos+1] == '(' {
+				// process substitution
+				p.inWord = true
+				p.pos++
+				p.substitution()
+				continue
+			}
+			// a word made of digits before the operator is a file descriptor
+			if p.inWord && isDigits(p.word.String()) {
+				p.word.Reset()
+				p.inWord = false
+			}
+			p.redirect()
+		case c == '#' && !p.inWord:
+			for p.pos < len(p.line) && p.line[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '\\':
+			p.inWord = true
+			if p.pos+1 < len(p.line) && p.line[p.pos+1] != '\n' {
+				p.word.WriteByte(p.line[p.pos+1])
+			}
+			p.pos += 2
+		case c == '\'':
+			p.inWord = true
+			end := strings.IndexByte(p.line[p.pos+1:], '\'')
+			if end < 0 {
+				p.invalid = "unterminated single quote"
+				return
+			}
+			p.word.WriteString(p.line[p.pos+1 : p.pos+1+end])
+			p.pos += end + 2
+		case c == '"':
+			p.inWord = true
+			p.pos++
+			p.doubleQuoted()
+		case c == '`':
+			p.inWord = true
+			p.dynamic = true
+			p.backticks()
+		case c == '$':
+			p.inWord = true
+			p.dollar()
+		default:
+			p.inWord = true
+			p.word.WriteByte(c)
+			p.pos++
+		}
+	}
+	if p.invalid == "" {
+		p.endCommand()
+	}
+}
+
+func (p *commandParser) doubleQuoted() {
+	for p.pos < len(p.line) {
+		switch c := p.line[p.pos]; c {
+		case '"':
+			p.pos++
+			return
+		case '\\':
+			if p.pos+1 < len(p.line) && strings.IndexByte("$`\"\\\n", p.line[p.pos+1]) >= 0 {
+				if p.line[p.pos+1] != '\n' {
+					p.word.WriteByte(p.line[p.pos+1])
+				}
+				p.pos += 2
+				continue
+			}
+			p.word.WriteByte(c)
+			p.pos++
+		case '`':
+			p.dynamic = true
+			p.backticks()
+			if p.invalid != "" {
+				return
+			}
+		case '$':
+			p.dollar()
+			if p.invalid != "" {
+				return
+			}
+		default:
+			p.word.WriteByte(c)
+			p.pos++
+		}
+	}
+	p.invalid = "unterminated double quote"
+}
+
+// dollar handles $ at p.pos: command and arithmetic substitutions are parsed,
+// variables are kept as written and mark the word as dynamic.
+func (p *commandParser) dollar() {
+	rest := p.line[p.pos+1:]
+	switch {
+	case strings.HasPrefix(rest, "("):
+		p.dynamic = true
+		p.pos++
+		p.substitution()
+	case strings.HasPrefix(rest, "{"):
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			p.invalid = "unterminated ${"
+			return
+		}
+		p.dynamic = true
+		p.word.WriteString(p.line[p.pos : p.pos+end+2])
+		p.pos += end + 2
+	case rest != "" && (isNameByte(rest[0]) || strings.IndexByte("@*#?$!-0123456789", rest[0]) >= 0):
+		p.dynamic = true
+		p.word.WriteByte('$')
+		p.pos++
+		for p.pos < len(p.line) && isNameByte(p.line[p.pos]) {
+			p.word.WriteByte(p.line[p.pos])
+			p.pos++
+		}
+	default:
+		p.word.WriteByte('$')
+		p.pos++
+	}
+}
+
+// substitution parses the body of a $(...), <(...) or >(...) starting at the
+// opening parenthesis at p.pos.
+func (p *commandParser) substitution() {
+	start := p.pos + 1
+	depth := 0
+	for i := p.pos; i < len(p.line); i++ {
+		switch p.line[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(p.line[i+1:], '\'')
+			if end < 0 {
+				p.invalid = "unterminated single quote"
+				return
+			}
+			i += end + 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				p.parsed.compound = true
+				p.word.WriteString("$(…)")
+				parseInto(p.parsed, p.line[start:i], p.depth+1)
+				p.pos = i + 1
+				return
+			}
+		}
+	}
+	p.invalid = "unterminated command substitution"
+}
+
+func (p *commandParser) backticks() {
+	var body strings.Builder
+	for i := p.pos + 1; i < len(p.line); i++ {
+		switch c := p.line[i]; c {
+		case '\\':
+			if i+1 < len(p.line) {
+				body.WriteByte(p.line[i+1])
+				i++
+			}
+		case '`':
+			p.parsed.compound = true
+			p.word.WriteString("`…`")
+			parseInto(p.parsed, body.String(), p.depth+1)
+			p.pos = i + 1
+			return
+		default:
+			body.WriteByte(c)
+		}
+	}
+	p.invalid = "unterminated backquote"
+}
+
+// redirect skips a redirection operator at p.pos; its target word is dropped.
+func (p *commandParser) redirect() {
+	p.endWord()
+	p.parsed.compound = true
+	for p.pos < len(p.line) && strings.IndexByte("<>&|-", p.line[p.pos]) >= 0 {
+		p.pos++
+	}
+	// >&2 and similar duplicate a descriptor instead of naming a file
+	if p.pos < len(p.line) && isDigit(p.line[p.pos]) && strings.ContainsAny(p.line[p.pos-1:p.pos], "&") {
+		for p.pos < len(p.line) && isDigit(p.line[p.pos]) {
+			p.pos++
+		}
+		return
+	}
+	p.skipWord = true
+}
+
+func (p *commandParser) endWord() {
+	if !p.inWord {
+		return
+	}
+	word, dynamic := p.word.String(), p.dynamic
+	p.word.Reset()
+	p.inWord, p.dynamic = false, false
+
+	if p.skipWord {
+		p.skipWord = false
+		return
+	}
+	// leading VAR=value assignments and keywords such as if or { are not
+	// part of the command
+	if len(p.words) == 0 && !dynamic {
+		if isAssignment(word) {
+			return
+		}
+		if reservedWords[word] {
+			p.parsed.compound = true
+			return
+		}
+	}
+	if len(p.words) == 0 && dynamic {
+		p.dynamicName = true
+	}
+	p.words = append(p.words, word)
+}
+
+func (p *commandParser) endCommand() {
+	p.endWord()
+	words, dynamicName := p.words, p.dynamicName
+	p.words, p.dynamicName, p.skipWord = nil, false, false
+	if len(words) == 0 {
+		return
+	}
+	if dynamicName {
+		p.invalid = "the command name is only known at run time"
+		return
+	}
+
+	p.parsed.commands = append(p.parsed.commands, words)
+
+	// Scripts run by a shell or eval and commands run by find are
+	// commands too
+	inner := unwrapCommand(words)
+	if len(inner) == 0 {
+		return
+	}
+	switch name := inner[0]; {
+	case name == "eval":
+		p.parsed.compound = true
+		parseInto(p.parsed, strings.Join(inner[1:], " "), p.depth+1)
+	case shells[name]:
+		for i := 1; i < len(inner)-1; i++ {
+			if strings.HasPrefix(inner[i], "-") && !strings.HasPrefix(inner[i], "--") && strings.Contains(inner[i], "c") {
+				p.parsed.compound = true
+				parseInto(p.parsed, inner[i+1], p.depth+1)
+				break
+			}
+		}
+	case name == "find":
+		for i := 1; i < len(inner); i++ {
+			switch inner[i] {
+			case "-exec", "-execdir", "-ok", "-okdir":
+				end := i + 1
+				for end < len(inner) && inner[end] != ";" && inner[end] != "+" {
+					end++
+				}
+				if end > i+1 {
+					p.parsed.compound = true
+					p.parsed.commands = append(p.parsed.commands, inner[i+1:end])
+				}
+				i = end
+			}
+		}
+	}
+}
+
+// unwrapCommand drops wrappers such as sudo or env and their options, and
+// reduces the command name to its base name, so /bin/rm and sudo rm are
+// both checked as rm.
+func unwrapCommand(words []string) []string {
+	for len(words) > 0 {
+		name := path.Base(words[0])
+		argOptions, wrapper := shellWrappers[name]
+		if !wrapper {
+			return append([]string{name}, words[1:]...)
+		}
+
+		words = words[1:]
+		for len(words) > 0 && strings.HasPrefix(words[0], "-") && len(words[0]) > 1 {
+			option := words[0]
+			words = words[1:]
+			if option == "--" {
+				break
+			}
+			// GNU coreutils built as a single binary names the program
+			// in an option
+			if prog, ok := strings.CutPrefix(option, "--coreutils-prog="); ok && name == "coreutils" {
+				words = append([]string{prog}, words...)
+				break
+			}
+			if slices.Contains(argOptions, option) && len(words) > 0 {
+				words = words[1:]
+			}
+		}
+		switch {
+		case name == "env":
+			for len(words) > 0 && isAssignment(words[0]) {
+				words = words[1:]
+			}
+		case name == "timeout" && len(words) > 0:
+			// the duration
+			words = words[1:]
+		}
+	}
+	return nil
+}
+
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" || isDigit(name[0]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameByte(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
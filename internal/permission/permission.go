@@ -0,0 +1,313 @@
+package permission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode decides which tool calls need the user's approval.
+type Mode string
+
+const (
+	// ModeAsk asks before every tool call.
+	ModeAsk Mode = "ask"
+	// ModeAskMutating asks only before calls of tools that change something.
+	ModeAskMutating Mode = "ask-mutating"
+	// ModeAuto runs every call without asking.
+	ModeAuto Mode = "auto"
+	// ModeDeny refuses every call of a mutating tool without asking.
+	ModeDeny Mode = "deny"
+)
+
+var Modes = []Mode{ModeAsk, ModeAskMutating, ModeAuto, ModeDeny}
+
+// AtLeastAsStrict reports whether mode m requires approval for at least the
+// tool calls other does.
+func (m Mode) AtLeastAsStrict(other Mode) bool {
+	return m.strictness() >= other.strictness()
+}
+
+func (m Mode) strictness() int {
+	switch m {
+	case ModeAuto:
+		return 0
+	case ModeAskMutating:
+		return 1
+	case ModeAsk:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// ErrDenied is wrapped by the error reported for a refused tool call.
+var ErrDenied = errors.New("permission denied")
+
+func ParseMode(s string) (Mode, error) {
+	for _, mode := range Modes {
+		if string(mode) == s {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown permission mode %q, expected one of ask, ask-mutating, auto, deny", s)
+}
+
+// Request describes a tool call waiting for permission.
+type Request struct {
+	Tool      string
+	Arguments json.RawMessage
+	ReadOnly  bool
+	CallID    string
+	// Command and Paths are what the call acts on, for matching rules. Paths
+	// are slash separated and relative to the workspace root.
+	Command string
+	Paths   []string
+}
+
+type Answer int
+
+const (
+	AnswerNo Answer = iota
+	AnswerYes
+	// AnswerAlways approves the call and every later call of the same tool
+	// for the rest of the session.
+	AnswerAlways
+)
+
+// Prompter asks the user to approve a tool call.
+type Prompter interface {
+	Confirm(ctx context.Context, req Request) (Answer, error)
+}
+
+// Decision is the outcome of a permission check.
+type Decision struct {
+	Allowed bool
+	// Source is what decided: "rule", "mode", "session" or "user".
+	Source string
+	Reason string
+	// Rule is the matching rule when Source is "rule".
+	Rule string
+}
+
+// Err returns the error reported to the model for a denied call.
+func (d Decision) Err() error {
+	return fmt.Errorf("%w: %s", ErrDenied, d.Reason)
+}
+
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+}
+
+// Gate is consulted before each tool call.
+type Gate struct {
+	mu       sync.Mutex
+	mode     Mode
+	prompter Prompter
+	always   map[string]bool
+	rules    *Rules
+	audit    io.Writer
+	logger   Logger
+
+	// prompting serializes prompts of concurrent tool calls
+	prompting sync.Mutex
+
+	// unapproved are tools denied because nobody could approve them
+	unapproved []string
+}
+
+func NewGate(mode Mode, logger Logger) *Gate {
+	return &Gate{
+		mode:   mode,
+		always: make(map[string]bool),
+		logger: logger,
+	}
+}
+
+// SetPrompter sets how the user is asked. Without a prompter, calls that
+// need approval are denied.
+func (g *Gate) SetPrompter(prompter Prompter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.prompter = prompter
+}
+
+// SetRules sets the allow and deny rules checked before the mode.
+func (g *Gate) SetRules(rules *Rules) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rules = rules
+}
+
+// SetAuditLog makes the gate write every decision as a JSON line to w.
+func (g *Gate) SetAuditLog(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.audit = w
+}
+
+func (g *Gate) Mode() Mode {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.mode
+}
+
+func (g *Gate) SetMode(mode Mode) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.mode = mode
+}
+
+// ResetSession forgets tools approved with AnswerAlways.
+func (g *Gate) ResetSession() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.always = make(map[string]bool)
+	g.unapproved = nil
+}
+
+// Unapproved returns the tools whose calls were denied because they needed
+// approval and there was no prompter, as in non-interactive runs.
+func (g *Gate) Unapproved() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.unapproved...)
+}
+
+// Check decides whether a tool call may run, asking the user if the mode
+// requires it.
+func (g *Gate) Check(ctx context.Context, req Request) Decision {
+	g.mu.Lock()
+	mode, rules := g.mode, g.rules
+	g.mu.Unlock()
+
+	var decision Decision
+	match, matched := Match{}, false
+	if rules != nil {
+		match, matched = rules.Match(req)
+	}
+
+	// Rules can only narrow deny mode, so an allow rule does not override it
+	switch {
+	case matched && !match.Allowed:
+		decision = Decision{Source: "rule", Reason: fmt.Sprintf("denied by rule %s in %s; do not retry this call", match.Rule, rules.Source()), Rule: match.Rule}
+	case mode == ModeDeny && !req.ReadOnly:
+		decision = Decision{Source: "mode", Reason: fmt.Sprintf("permission mode deny does not allow running %s", req.Tool)}
+	case matched:
+		decision = Decision{Allowed: true, Source: "rule", Reason: "allowed by rule " + match.Rule, Rule: match.Rule}
+	case mode == ModeAuto:
+		decision = Decision{Allowed: true, Source: "mode", Reason: "permission mode is auto"}
+	case req.ReadOnly && mode != ModeAsk:
+		decision = Decision{Allowed: true, Source: "mode", Reason: "tool is read-only"}
+	default:
+		decision = g.ask(ctx, req)
+	}
+
+	g.record(req, decision)
+	return decision
+}
+
+func (g *Gate) ask(ctx context.Context, req Request) Decision {
+	g.prompting.Lock()
+	defer g.prompting.Unlock()
+
+	g.mu.Lock()
+	approved, prompter := g.always[req.Tool], g.prompter
+	g.mu.Unlock()
+
+	if approved {
+		return Decision{Allowed: true, Source: "session", Reason: fmt.Sprintf("%s was approved for this session", req.Tool)}
+	}
+	if prompter == nil {
+		g.mu.Lock()
+		if !slices.Contains(g.unapproved, req.Tool) {
+			g.unapproved = append(g.unapproved, req.Tool)
+		}
+		g.mu.Unlock()
+		return Decision{Source: "mode", Reason: fmt.Sprintf("running %s requires approval, but there is no user to approve it", req.Tool)}
+	}
+
+	answer, err := prompter.Confirm(ctx, req)
+	if err != nil {
+		return Decision{Source: "user", Reason: fmt.Sprintf("approval was not given: %v", err)}
+	}
+
+	switch answer {
+	case AnswerAlways:
+		g.mu.Lock()
+		g.always[req.Tool] = true
+		g.mu.Unlock()
+		return Decision{Allowed: true, Source: "user", Reason: fmt.Sprintf("user approved %s for this session", req.Tool)}
+	case AnswerYes:
+		return Decision{Allowed: true, Source: "user", Reason: "user approved the call"}
+	default:
+		return Decision{Source: "user", Reason: fmt.Sprintf("the user declined to run %s; do not retry this call, ask the user how to proceed instead", req.Tool)}
+	}
+}
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time      time.Time       `json:"time"`
+	Tool      string          `json:"tool"`
+	CallID    string          `json:"call_id,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Allowed   bool            `json:"allowed"`
+	Source    string          `json:"source"`
+	Rule      string          `json:"rule,omitempty"`
+	Reason    string          `json:"reason"`
+}
+
+func (g *Gate) record(req Request, decision Decision) {
+	if decision.Allowed {
+		g.logger.Debug("Permission granted for %s (%s): %s", req.Tool, decision.Source, decision.Reason)
+	} else {
+		g.logger.Debug("Permission denied for %s (%s): %s", req.Tool, decision.Source, decision.Reason)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.audit == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Time:    time.Now(),
+		Tool:    req.Tool,
+		CallID:  req.CallID,
+		Allowed: decision.Allowed,
+		Source:  decision.Source,
+		Rule:    decision.Rule,
+		Reason:  decision.Reason,
+	}
+	if json.Valid(req.Arguments) {
+		entry.Arguments = req.Arguments
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if _, err := g.audit.Write(append(data, '\n')); err != nil {
+		g.logger.Warn("Failed to write audit log: %v", err)
+	}
+}
+
+// ParseAnswer interprets a typed reply to an approval prompt. Anything other
+// than yes or always is a no.
+func ParseAnswer(reply string) Answer {
+	switch strings.ToLower(strings.TrimSpace(reply)) {
+	case "y", "yes":
+		return AnswerYes
+	case "a", "always":
+		return AnswerAlways
+	default:
+		return AnswerNo
+	}
+}
@@ -0,0 +1,35 @@
+package permission
+
+import (
+	"context"
+	"testing"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(format string, args ...interface{}) {}
+func (nopLogger) Warn(format string, args ...interface{})  {}
+
+func TestDenyModeIgnoresAllowRules(t *testing.T) {
+	gate := NewGate(ModeDeny, nopLogger{})
+	gate.SetRules(loadTestRules(t, `
+bash:
+  allow: ["go test *"]
+  deny: ["cat .env"]
+`))
+
+	tests := []struct {
+		req     Request
+		allowed bool
+	}{
+		{Request{Tool: "bash", Command: "go test ./..."}, false},
+		{Request{Tool: "bash", Command: "ls"}, false},
+		{Request{Tool: "read_file", ReadOnly: true}, true},
+		{Request{Tool: "bash", Command: "cat .env", ReadOnly: true}, false},
+	}
+	for _, tt := range tests {
+		if got := gate.Check(context.Background(), tt.req); got.Allowed != tt.allowed {
+			t.Errorf("%s %q: allowed = %v (%s), want %v", tt.req.Tool, tt.req.Command, got.Allowed, got.Reason, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package permission
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"gocopilot/internal/glob"
+)
+
+// DefaultRulesFile is where rules are looked up, relative to the workspace root.
+const DefaultRulesFile = ".gocopilot/permissions.yaml"
+
+// regexPrefix marks a pattern as a regular expression instead of a glob.
+const regexPrefix = "re:"
+
+// Rules are allow and deny patterns for bash commands and for the paths
+// mutating tools write to. Deny rules win over allow rules and over the
+// permission mode; allow rules take precedence over every mode but deny.
+type Rules struct {
+	source string
+	bash   ruleSet
+	paths  ruleSet
+}
+
+type ruleSet struct {
+	allow []pattern
+	deny  []pattern
+}
+
+type pattern struct {
+	text string
+	re   *regexp.Regexp
+	// cmd is set for bash globs and used to check them as deny rules.
+	cmd *commandPattern
+}
+
+// commandPattern is a bash glob split into the command name, the options it
+// requires and a pattern for its remaining arguments.
+type commandPattern struct {
+	name  string
+	short string
+	long  []string
+	args  *regexp.Regexp
+}
+
+// Match is the rule that decided a request.
+type Match struct {
+	Allowed bool
+	// Rule identifies the rule, e.g. "bash.deny: rm -rf *".
+	Rule string
+}
+
+type rulesFile struct {
+	Bash  rulesFileSet `yaml:"bash"`
+	Paths rulesFileSet `yaml:"paths"`
+}
+
+type rulesFileSet struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// LoadRules reads a rules file. A missing file yields no rules and no error.
+func LoadRules(file string) (*Rules, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read permission rules: %w", err)
+	}
+
+	var raw rulesFile
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse permission rules %s: %w", file, err)
+	}
+
+	rules := &Rules{source: file}
+	if rules.bash, err = compileRuleSet(raw.Bash, false); err != nil {
+		return nil, fmt.Errorf("invalid bash rule in %s: %w", file, err)
+	}
+	if rules.paths, err = compileRuleSet(raw.Paths, true); err != nil {
+		return nil, fmt.Errorf("invalid path rule in %s: %w", file, err)
+	}
+	return rules, nil
+}
+
+func (r *Rules) Source() string {
+	return r.source
+}
+
+// Count returns the number of rules.
+func (r *Rules) Count() int {
+	return len(r.bash.allow) + len(r.bash.deny) + len(r.paths.allow) + len(r.paths.deny)
+}
+
+// Match returns the rule deciding req, if any. A request is allowed by rules
+// only if its command and every path it writes are allowed; otherwise the
+// permission mode decides.
+func (r *Rules) Match(req Request) (Match, bool) {
+	var allowed []string
+	undecided := false
+
+	if req.Command != "" {
+		rule, ok := r.matchCommand(req.Command)
+		switch {
+		case ok && !rule.Allowed:
+			return rule, true
+		case ok:
+			allowed = append(allowed, rule.Rule)
+		default:
+			undecided = true
+		}
+	}
+
+	if !req.ReadOnly {
+		for _, p := range req.Paths {
+			rule, ok := r.paths.match("paths", p)
+			switch {
+			case ok && !rule.Allowed:
+				return rule, true
+			case ok:
+				if !slices.Contains(allowed, rule.Rule) {
+					allowed = append(allowed, rule.Rule)
+				}
+			default:
+				undecided = true
+			}
+		}
+	}
+
+	if undecided || len(allowed) == 0 {
+		return Match{}, false
+	}
+	return Match{Allowed: true, Rule: strings.Join(allowed, "; ")}, true
+}
+
+// matchCommand checks every command a command line runs, including those
+// in substitutions and "sh -c" scripts, against the deny rules. A line that
+// cannot be parsed is denied when there are deny rules. Allow rules only
+// apply to a line that runs a single command without chaining, redirections
+// or substitutions.
+func (r *Rules) matchCommand(command string) (Match, bool) {
+	parsed := parseCommand(command)
+
+	if len(r.bash.deny) > 0 && parsed.invalid != "" {
+		return Match{Rule: "bash.deny: command cannot be checked (" + parsed.invalid + ")"}, true
+	}
+	for _, p := range r.bash.deny {
+		if p.cmd == nil && p.re.MatchString(command) {
+			return Match{Rule: "bash.deny: " + p.text}, true
+		}
+		for _, words := range parsed.commands {
+			if p.matchesDenied(unwrapCommand(words)) {
+				return Match{Rule: "bash.deny: " + p.text}, true
+			}
+		}
+	}
+
+	if parsed.invalid != "" || parsed.compound || len(parsed.commands) != 1 {
+		return Match{}, false
+	}
+	subject := strings.Join(parsed.commands[0], " ")
+	for _, p := range r.bash.allow {
+		if p.re.MatchString(subject) {
+			return Match{Allowed: true, Rule: "bash.allow: " + p.text}, true
+		}
+	}
+	return Match{}, false
+}
+
+// matchesDenied reports whether a deny rule covers a command whose wrappers
+// were removed by unwrapCommand. Globs compare the command's base name and
+// accept the options they name in any order or grouping, so "rm -rf *" also
+// covers "rm -fr /" and "rm -r -f /".
+func (p pattern) matchesDenied(words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	if p.cmd == nil {
+		return p.re.MatchString(strings.Join(words, " "))
+	}
+	if ok, _ := path.Match(p.cmd.name, words[0]); !ok {
+		return false
+	}
+
+	short, long, args := splitOptions(words[1:])
+	for _, c := range p.cmd.short {
+		if !strings.ContainsRune(short, c) {
+			return false
+		}
+	}
+	for _, want := range p.cmd.long {
+		if !slices.ContainsFunc(long, func(option string) bool {
+			ok, _ := path.Match(want, option)
+			return ok
+		}) {
+			return false
+		}
+	}
+	return p.cmd.args.MatchString(strings.Join(args, " "))
+}
+
+// splitOptions separates the letters of short options, the names of long
+// options and the remaining arguments of a command.
+func splitOptions(words []string) (short string, long, args []string) {
+	for i, word := range words {
+		switch {
+		case word == "--":
+			return short, long, append(args, words[i+1:]...)
+		case strings.HasPrefix(word, "--"):
+			name, _, _ := strings.Cut(word, "=")
+			long = append(long, name)
+		case strings.HasPrefix(word, "-") && len(word) > 1:
+			short += word[1:]
+		default:
+			args = append(args, word)
+		}
+	}
+	return short, long, args
+}
+
+// compileCommandPattern splits a bash glob for deny checks. An option with a
+// trailing *, as in "rm -rf*", also allows any arguments.
+func compileCommandPattern(glob string) (*commandPattern, error) {
+	words := strings.Fields(glob)
+	if len(words) == 0 {
+		return nil, errors.New("empty pattern")
+	}
+
+	cmd := &commandPattern{name: path.Base(words[0])}
+	var args []string
+	for _, word := range words[1:] {
+		switch {
+		case strings.HasPrefix(word, "--"):
+			cmd.long = append(cmd.long, word)
+		case strings.HasPrefix(word, "-") && len(word) > 1:
+			letters := strings.TrimRight(word[1:], "*")
+			cmd.short += letters
+			if letters != word[1:] {
+				args = append(args, "*")
+			}
+		default:
+			args = append(args, word)
+		}
+	}
+
+	var err error
+	cmd.args, err = regexp.Compile(commandGlobToRegexp(strings.Join(args, " ")))
+	return cmd, err
+}
+
+func (s ruleSet) match(kind, subject string) (Match, bool) {
+	for _, p := range s.deny {
+		if p.re.MatchString(subject) {
+			return Match{Rule: kind + ".deny: " + p.text}, true
+		}
+	}
+	for _, p := range s.allow {
+		if p.re.MatchString(subject) {
+			return Match{Allowed: true, Rule: kind + ".allow: " + p.text}, true
+		}
+	}
+	return Match{}, false
+}
+
+func compileRuleSet(raw rulesFileSet, paths bool) (ruleSet, error) {
+	var set ruleSet
+	var err error
+	if set.allow, err = compilePatterns(raw.Allow, paths); err != nil {
+		return set, err
+	}
+	if set.deny, err = compilePatterns(raw.Deny, paths); err != nil {
+		return set, err
+	}
+	return set, nil
+}
+
+func compilePatterns(texts []string, paths bool) ([]pattern, error) {
+	patterns := make([]pattern, 0, len(texts))
+	for _, text := range texts {
+		var expr string
+		switch {
+		case strings.HasPrefix(text, regexPrefix):
+			expr = strings.TrimPrefix(text, regexPrefix)
+		case paths:
+			// * and ? stay within one path element, ** spans any
+			// number of them
+			expr = "^" + glob.ToRegexp(path.Clean(text)) + "$"
+		default:
+			expr = commandGlobToRegexp(strings.TrimSpace(text))
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", text, err)
+		}
+		p := pattern{text: text, re: re}
+		if !paths && !strings.HasPrefix(text, regexPrefix) {
+			if p.cmd, err = compileCommandPattern(text); err != nil {
+				return nil, fmt.Errorf("%q: %w", text, err)
+			}
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// commandGlobToRegexp matches a whole command, where * matches anything. A
+// trailing " *" also matches the command without arguments.
+func commandGlobToRegexp(glob string) string {
+	optionalArgs := strings.HasSuffix(glob, " *")
+	if optionalArgs {
+		glob = strings.TrimSuffix(glob, " *")
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i, part := range strings.Split(glob, "*") {
+		if i > 0 {
+			b.WriteString(".*")
+		}
+		b.WriteString(regexp.QuoteMeta(part))
+	}
+	if optionalArgs {
+		b.WriteString("(\\s.*)?")
+	}
+	b.WriteString("$")
+	return b.String()
+}
@@ -0,0 +1,192 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const fileExtension = ".json"
+
+// ErrNotFound is returned when a session ID has no file in the store.
+var ErrNotFound = errors.New("session not found")
+
+// Session is the persisted state of a conversation. Messages are stored in
+// the OpenAI wire format so they round-trip through the SDK unchanged.
+type Session struct {
+	ID        string            `json:"id"`
+	Title     string            `json:"title,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Model     string            `json:"model"`
+	Tools     []string          `json:"tools,omitempty"`
+	Settings  *Settings         `json:"settings,omitempty"`
+	System    []json.RawMessage `json:"system,omitempty"`
+	Summary   string            `json:"summary,omitempty"`
+	History   []json.RawMessage `json:"history"`
+}
+
+// Settings is the tool configuration a session ran with, restored when it
+// is resumed.
+type Settings struct {
+	Shell           string         `json:"shell,omitempty"`
+	PermissionMode  string         `json:"permission_mode,omitempty"`
+	PermissionsFile string         `json:"permissions_file,omitempty"`
+	ToolTimeout     int            `json:"tool_timeout"`
+	ToolTimeouts    map[string]int `json:"tool_timeouts,omitempty"`
+}
+
+// Info describes a stored session for listings.
+type Info struct {
+	ID        string
+	Title     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Model     string
+	Messages  int
+}
+
+// Store keeps one JSON file per session in a directory.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// NewID returns a sortable, human readable session ID.
+func NewID() string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// Save writes the session atomically, replacing any previous version.
+func (s *Store) Save(sess *Session) error {
+	if err := validateID(sess.ID); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, sess.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create session file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(sess.ID)); err != nil {
+		return fmt.Errorf("failed to save session file: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Load(id string) (*Session, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to read session %s: %w", id, err)
+	}
+
+	var sess Session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, fmt.Errorf("failed to decode session %s: %w", id, err)
+	}
+	return &sess, nil
+}
+
+// Latest returns the most recently updated session.
+func (s *Store) Latest() (*Session, error) {
+	infos, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, ErrNotFound
+	}
+	return s.Load(infos[0].ID)
+}
+
+// List returns all stored sessions, most recently updated first. Files that
+// cannot be decoded are skipped.
+func (s *Store) List() ([]Info, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read session directory: %w", err)
+	}
+
+	var infos []Info
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, fileExtension) {
+			continue
+		}
+
+		sess, err := s.Load(strings.TrimSuffix(name, fileExtension))
+		if err != nil {
+			continue
+		}
+
+		infos = append(infos, Info{
+			ID:        sess.ID,
+			Title:     sess.Title,
+			CreatedAt: sess.CreatedAt,
+			UpdatedAt: sess.UpdatedAt,
+			Model:     sess.Model,
+			Messages:  len(sess.History),
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UpdatedAt.After(infos[j].UpdatedAt)
+	})
+	return infos, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+fileExtension)
+}
+
+// validateID keeps IDs from escaping the session directory.
+func validateID(id string) error {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("invalid session ID %q", id)
+	}
+	return nil
+}
@@ -2,13 +2,27 @@ package tools
 
 import ()
 
-func RegisterBuiltinTools(registry *Registry, log interface{ Debug(format string, args ...interface{}); Info(format string, args ...interface{}); Error(format string, args ...interface{}) }) error {
+// Options configures the built-in tools.
+type Options struct {
+	// Shell is the shell the bash tool runs commands with, a key of Shells.
+	Shell string
+}
+
+func RegisterBuiltinTools(registry *Registry, opts Options, log interface{ Debug(format string, args ...interface{}); Info(format string, args ...interface{}); Error(format string, args ...interface{}) }) error {
+	bash, err := NewBashDefinition(opts.Shell)
+	if err != nil {
+		log.Error("Failed to configure bash tool: %v", err)
+		return err
+	}
+
 	tools := []ToolDefinition{
 		ReadFileDefinition,
 		ListFilesDefinition,
-		BashDefinition,
+		bash,
 		EditFileDefinition,
+		ApplyPatchDefinition,
 		CodeSearchDefinition,
+		GoSymbolsDefinition,
 	}
 
 	for _, tool := range tools {
@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// lcsLength is the length of a longest common subsequence, for checking that
+// diffLines finds a shortest edit script.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// checkEditScript checks that ops turn a into b with wantEdits insertions
+// and deletions, or with as few as possible if wantEdits is negative.
+func checkEditScript(t *testing.T, a, b []string, ops []diffOp, wantEdits int) {
+	t.Helper()
+	var old, new []string
+	edits := 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			old = append(old, op.line)
+		}
+		if op.kind != '-' {
+			new = append(new, op.line)
+		}
+		if op.kind != ' ' {
+			edits++
+		}
+	}
+	if strings.Join(old, "|") != strings.Join(a, "|") || strings.Join(new, "|") != strings.Join(b, "|") {
+		t.Fatalf("edit script does not turn %d lines into %d lines", len(a), len(b))
+	}
+	if wantEdits < 0 {
+		wantEdits = len(a) + len(b) - 2*lcsLength(a, b)
+	}
+	if edits != wantEdits {
+		t.Fatalf("%d edits, want %d", edits, wantEdits)
+	}
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(), randomLines()
+		checkEditScript(t, a, b, diffLines(a, b), -1)
+	}
+}
+
+func TestDiffLinesLargeInputs(t *testing.T) {
+	lines := func(n int, prefix string) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = prefix + string(rune('a'+i%26)) + "\n"
+		}
+		return out
+	}
+
+	// One side empty
+	big := lines(200000, "x")
+	checkEditScript(t, nil, big, diffLines(nil, big), len(big))
+	checkEditScript(t, big, nil, diffLines(big, nil), len(big))
+
+	// A small change in a large file keeps the shortest script
+	changed := append([]string(nil), big...)
+	changed[100000] = "changed\n"
+	checkEditScript(t, big, changed, diffLines(big, changed), 2)
+
+	// A rewrite beyond maxDiffLines replaces the changed lines
+	other := lines(maxDiffLines, "y")
+	checkEditScript(t, big[:maxDiffLines], other, diffLines(big[:maxDiffLines], other), 2*maxDiffLines)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	got := UnifiedDiff("a/f", "b/f", "one\ntwo\nthree\n", "one\n2\nthree")
+	want := "--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n one\n-two\n-three\n+2\n+three\n\\ No newline at end of file\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := UnifiedDiff("a/f", "b/f", "same\n", "same\n"); got != "" {
+		t.Errorf("diff of equal texts = %q", got)
+	}
+}
@@ -0,0 +1,79 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+)
+
+// Logger is the logging interface available to tools.
+type Logger = interface {
+	Debug(format string, args ...interface{})
+	Error(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+}
+
+// Env describes the environment a single tool call runs in.
+type Env struct {
+	// WorkspaceRoot is the directory the agent works in. Relative paths in
+	// tool input are interpreted against it.
+	WorkspaceRoot string
+	// Workspace resolves paths and rejects those outside the workspace.
+	Workspace *Workspace
+	Logger    Logger
+	// Output receives progress a tool produces while it is still running,
+	// such as live command output. The returned string is what the model sees.
+	Output io.Writer
+	CallID string
+	// Journal records the file changes of the call; nil disables recording.
+	Journal *Journal
+}
+
+// recordChange adds a file change made by the call to the journal. mode is
+// the permission bits the file had before, or 0 if unknown.
+func (e *Env) recordChange(path string, before, after *string, mode fs.FileMode) {
+	if e.Journal == nil {
+		return
+	}
+	e.Journal.Record(e.CallID, path, e.Workspace.Rel(path), before, after, mode)
+}
+
+// ToolFunc is the function contract for tools. The context is cancelled when
+// the current turn is cancelled, so long running tools must observe it.
+type ToolFunc func(ctx context.Context, env *Env, input json.RawMessage) (string, error)
+
+// LegacyFunc adapts a tool written against the original Function signature,
+// which neither observes cancellation nor knows the workspace root.
+func LegacyFunc(fn func(input json.RawMessage, log Logger) (string, error)) ToolFunc {
+	return func(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+		return fn(input, env.Logger)
+	}
+}
+
+// NewEnv returns an environment with defaults for the fields that are unset.
+// Without a workspace, tools are confined to the current directory.
+func NewEnv(workspace *Workspace, log Logger, output io.Writer, callID string) *Env {
+	if workspace == nil {
+		workspace = NewWorkspace(".")
+	}
+	if log == nil {
+		log = noopLogger{}
+	}
+	if output == nil {
+		output = io.Discard
+	}
+	return &Env{
+		WorkspaceRoot: workspace.Root(),
+		Workspace:     workspace,
+		Logger:        log,
+		Output:        output,
+		CallID:        callID,
+	}
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(format string, args ...interface{}) {}
+func (noopLogger) Error(format string, args ...interface{}) {}
+func (noopLogger) Warn(format string, args ...interface{})  {}
@@ -0,0 +1,715 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of go_symbols output
+const (
+	maxSymbolResults    = 100
+	maxDefinitionLines  = 60
+	maxSignatureRunes   = 300
+	maxCallerLineLength = 200
+)
+
+// goSkipDirs are never indexed, in addition to hidden and ignored ones.
+var goSkipDirs = map[string]bool{"vendor": true, "testdata": true, "node_modules": true}
+
+// goDecl is a top-level declaration. Methods have a receiver type name.
+type goDecl struct {
+	kind string // type, interface, func, method, var or const
+	name string
+	recv string
+	// pointer is set for methods with a pointer receiver
+	pointer bool
+	pkg     string
+	file    string
+	line    int
+	// signature is a one-line summary, source the full declaration
+	signature string
+	source    string
+	doc       string
+	// methods of an interface, and embedded types of an interface or struct
+	methods []goMethod
+	embeds  []string
+	params  int
+	results int
+}
+
+type goMethod struct {
+	name      string
+	line      int
+	signature string
+	params    int
+	results   int
+}
+
+type goCall struct {
+	callee string
+	// caller is the enclosing function, e.g. (*Agent).runTurn
+	caller string
+	file   string
+	line   int
+	text   string
+}
+
+type goFile struct {
+	modTime time.Time
+	size    int64
+	decls   []goDecl
+	calls   []goCall
+}
+
+// goIndex caches the parsed Go files of a workspace. Files are parsed again
+// when their size or modification time changes.
+type goIndex struct {
+	mu    sync.Mutex
+	files map[string]*goFile
+}
+
+var goIndexes = struct {
+	sync.Mutex
+	byRoot map[string]*goIndex
+}{byRoot: map[string]*goIndex{}}
+
+func goIndexFor(root string) *goIndex {
+	goIndexes.Lock()
+	defer goIndexes.Unlock()
+
+	index, ok := goIndexes.byRoot[root]
+	if !ok {
+		index = &goIndex{files: map[string]*goFile{}}
+		goIndexes.byRoot[root] = index
+	}
+	return index
+}
+
+// refresh brings the index up to date with the workspace and returns its
+// files by workspace-relative path.
+func (x *goIndex) refresh(ctx context.Context, workspace *Workspace, log Logger) (map[string]*goFile, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	root := workspace.Root()
+	ignore, _ := workspaceIgnoreMatcher(workspace, root)
+	seen := map[string]bool{}
+	parsed := 0
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if strings.HasPrefix(d.Name(), ".") || d.IsDir() && goSkipDirs[d.Name()] || ignore.ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			ignore.loadDir(rel)
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".go") || !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		seen[rel] = true
+		if cached, ok := x.files[rel]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return nil
+		}
+
+		file, err := parseGoFile(p, rel)
+		if err != nil {
+			log.Debug("Skipping %s: %v", rel, err)
+			delete(x.files, rel)
+			return nil
+		}
+		file.modTime, file.size = info.ModTime(), info.Size()
+		x.files[rel] = file
+		parsed++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for rel := range x.files {
+		if !seen[rel] {
+			delete(x.files, rel)
+		}
+	}
+	log.Debug("Go index has %d files, %d parsed again", len(x.files), parsed)
+
+	files := make(map[string]*goFile, len(x.files))
+	for rel, file := range x.files {
+		files[rel] = file
+	}
+	return files, nil
+}
+
+// parseGoFile extracts the declarations and calls of a file. Files with
+// syntax errors are indexed as far as they parse.
+func parseGoFile(p, rel string) (*goFile, error) {
+	src, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, p, src, parser.ParseComments|parser.SkipObjectResolution)
+	if f == nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(src), "\n")
+	file := &goFile{}
+	pkg := f.Name.Name
+	lineOf := func(pos token.Pos) int { return fset.Position(pos).Line }
+
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			fd := goDecl{
+				kind: "func",
+				name: d.Name.Name,
+				pkg:  pkg,
+				file: rel,
+				line: lineOf(d.Name.Pos()),
+				doc:  docText(d.Doc),
+			}
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				fd.kind = "method"
+				fd.recv, fd.pointer = receiverType(d.Recv.List[0].Type)
+			}
+			fd.params, fd.results = fieldCount(d.Type.Params), fieldCount(d.Type.Results)
+
+			header := *d
+			header.Body, header.Doc = nil, nil
+			fd.source = printNode(fset, &header)
+			fd.signature = oneLine(fd.source)
+			file.decls = append(file.decls, fd)
+
+			if d.Body != nil {
+				file.calls = append(file.calls, collectCalls(d.Body, callerName(d), rel, lines, lineOf)...)
+			}
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				doc := d.Doc
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Doc != nil {
+						doc = s.Doc
+					}
+					file.decls = append(file.decls, typeDecl(fset, s, pkg, rel, docText(doc), lineOf))
+				case *ast.ValueSpec:
+					if s.Doc != nil {
+						doc = s.Doc
+					}
+					source := d.Tok.String() + " " + printNode(fset, s)
+					for _, name := range s.Names {
+						file.decls = append(file.decls, goDecl{
+							kind:      d.Tok.String(),
+							name:      name.Name,
+							pkg:       pkg,
+							file:      rel,
+							line:      lineOf(name.Pos()),
+							signature: oneLine(source),
+							source:    source,
+							doc:       docText(doc),
+						})
+					}
+					for _, value := range s.Values {
+						file.calls = append(file.calls, collectCalls(value, "", rel, lines, lineOf)...)
+					}
+				}
+			}
+		}
+	}
+	return file, nil
+}
+
+func typeDecl(fset *token.FileSet, s *ast.TypeSpec, pkg, rel, doc string, lineOf func(token.Pos) int) goDecl {
+	td := goDecl{
+		kind:   "type",
+		name:   s.Name.Name,
+		pkg:    pkg,
+		file:   rel,
+		line:   lineOf(s.Name.Pos()),
+		doc:    doc,
+		source: "type " + printNode(fset, s),
+	}
+	td.signature = oneLine(td.source)
+
+	switch t := s.Type.(type) {
+	case *ast.InterfaceType:
+		td.kind = "interface"
+		td.signature = "type " + s.Name.Name + " interface"
+		for _, field := range t.Methods.List {
+			ft, ok := field.Type.(*ast.FuncType)
+			if !ok {
+				if name := embeddedType(field.Type); name != "" {
+					td.embeds = append(td.embeds, name)
+				}
+				continue
+			}
+			signature := strings.TrimPrefix(oneLine(printNode(fset, ft)), "func")
+			for _, name := range field.Names {
+				td.methods = append(td.methods, goMethod{
+					name:      name.Name,
+					line:      lineOf(name.Pos()),
+					signature: name.Name + signature,
+					params:    fieldCount(ft.Params),
+					results:   fieldCount(ft.Results),
+				})
+			}
+		}
+	case *ast.StructType:
+		td.signature = "type " + s.Name.Name + " struct"
+		for _, field := range t.Fields.List {
+			if len(field.Names) == 0 {
+				if name := embeddedType(field.Type); name != "" {
+					td.embeds = append(td.embeds, name)
+				}
+			}
+		}
+	}
+	return td
+}
+
+// collectCalls finds the calls in node; callee is the called name without
+// its package or receiver.
+func collectCalls(node ast.Node, caller, rel string, lines []string, lineOf func(token.Pos) int) []goCall {
+	var calls []goCall
+	ast.Inspect(node, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		fun := call.Fun
+		switch f := fun.(type) {
+		case *ast.IndexExpr:
+			fun = f.X
+		case *ast.IndexListExpr:
+			fun = f.X
+		}
+		var callee string
+		switch f := fun.(type) {
+		case *ast.Ident:
+			callee = f.Name
+		case *ast.SelectorExpr:
+			callee = f.Sel.Name
+		default:
+			return true
+		}
+
+		line := lineOf(call.Lparen)
+		text := ""
+		if line > 0 && line <= len(lines) {
+			text = strings.TrimSpace(lines[line-1])
+		}
+		calls = append(calls, goCall{callee: callee, caller: caller, file: rel, line: line, text: text})
+		return true
+	})
+	return calls
+}
+
+// receiverType returns the base type name of a receiver or embedded field,
+// e.g. Agent for *Agent or Cache[K, V].
+func receiverType(expr ast.Expr) (string, bool) {
+	pointer := false
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr, pointer = star.X, true
+	}
+	switch t := expr.(type) {
+	case *ast.IndexExpr:
+		expr = t.X
+	case *ast.IndexListExpr:
+		expr = t.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name, pointer
+	}
+	return "", pointer
+}
+
+// embeddedType returns the name of an embedded type as written, e.g. Cache
+// for *Cache[K] or io.Reader. Type sets of constraint interfaces yield "".
+func embeddedType(expr ast.Expr) string {
+	if name, _ := receiverType(expr); name != "" {
+		return name
+	}
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch t := expr.(type) {
+	case *ast.IndexExpr:
+		expr = t.X
+	case *ast.IndexListExpr:
+		expr = t.X
+	}
+	if sel, ok := expr.(*ast.SelectorExpr); ok {
+		if pkg, ok := sel.X.(*ast.Ident); ok {
+			return pkg.Name + "." + sel.Sel.Name
+		}
+	}
+	return ""
+}
+
+func callerName(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return d.Name.Name
+	}
+	recv, pointer := receiverType(d.Recv.List[0].Type)
+	if pointer {
+		return fmt.Sprintf("(*%s).%s", recv, d.Name.Name)
+	}
+	return fmt.Sprintf("%s.%s", recv, d.Name.Name)
+}
+
+func fieldCount(fields *ast.FieldList) int {
+	if fields == nil {
+		return 0
+	}
+	count := 0
+	for _, field := range fields.List {
+		count += max(len(field.Names), 1)
+	}
+	return count
+}
+
+func printNode(fset *token.FileSet, node any) string {
+	var b bytes.Buffer
+	if err := printer.Fprint(&b, fset, node); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// oneLine collapses a declaration to a single line of bounded length.
+func oneLine(source string) string {
+	line := strings.Join(strings.Fields(source), " ")
+	if runes := []rune(line); len(runes) > maxSignatureRunes {
+		line = string(runes[:maxSignatureRunes]) + "…"
+	}
+	return line
+}
+
+func docText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	text, _, _ := strings.Cut(strings.TrimSpace(doc.Text()), "\n\n")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func GoSymbols(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+	log := env.Logger
+	goSymbolsInput := GoSymbolsInput{}
+	err := json.Unmarshal(input, &goSymbolsInput)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimPrefix(strings.TrimSpace(goSymbolsInput.Name), "*")
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+
+	files, err := goIndexFor(env.Workspace.Root()).refresh(ctx, env.Workspace, log)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "No Go files in the workspace", nil
+	}
+
+	var decls []goDecl
+	var calls []goCall
+	for _, file := range files {
+		decls = append(decls, file.decls...)
+		calls = append(calls, file.calls...)
+	}
+	sort.Slice(decls, func(i, j int) bool {
+		if decls[i].file != decls[j].file {
+			return decls[i].file < decls[j].file
+		}
+		return decls[i].line < decls[j].line
+	})
+
+	log.Debug("go_symbols %s %s", goSymbolsInput.Query, name)
+
+	var lines []string
+	switch goSymbolsInput.Query {
+	case "definition":
+		lines = symbolDefinitions(decls, name)
+	case "methods":
+		lines = symbolMethods(decls, name)
+	case "callers":
+		lines = symbolCallers(calls, name)
+	case "implementations":
+		lines, err = symbolImplementations(decls, name)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown query %q, expected definition, methods, callers or implementations", goSymbolsInput.Query)
+	}
+
+	if len(lines) == 0 {
+		return fmt.Sprintf("No %s found for %s", goSymbolsInput.Query, name), nil
+	}
+	if len(lines) > maxSymbolResults {
+		lines = append(lines[:maxSymbolResults], fmt.Sprintf("... (showing first %d of %d results)", maxSymbolResults, len(lines)))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// matchDecls returns the declarations a name refers to: Name, Type.Method or
+// pkg.Name.
+func matchDecls(decls []goDecl, name string) []goDecl {
+	qualifier, member, dotted := strings.Cut(name, ".")
+	var matches []goDecl
+	for _, d := range decls {
+		switch {
+		case !dotted:
+			if d.name == name {
+				matches = append(matches, d)
+			}
+		case d.name == member && (d.recv == qualifier || d.recv == "" && d.pkg == qualifier):
+			matches = append(matches, d)
+		}
+	}
+	return matches
+}
+
+func symbolDefinitions(decls []goDecl, name string) []string {
+	var lines []string
+	for _, d := range matchDecls(decls, name) {
+		lines = append(lines, fmt.Sprintf("%s:%d: %s", d.file, d.line, d.kind))
+		if d.doc != "" {
+			lines = append(lines, "  // "+d.doc)
+		}
+		source := strings.Split(strings.TrimRight(d.source, "\n"), "\n")
+		if len(source) > maxDefinitionLines {
+			source = append(source[:maxDefinitionLines], "... (declaration truncated)")
+		}
+		for _, line := range source {
+			lines = append(lines, "  "+line)
+		}
+	}
+	return lines
+}
+
+func symbolMethods(decls []goDecl, name string) []string {
+	pkg, typeName, qualified := strings.Cut(name, ".")
+	if !qualified {
+		pkg, typeName = "", name
+	}
+
+	var lines []string
+	for _, d := range decls {
+		if pkg != "" && d.pkg != pkg {
+			continue
+		}
+		switch {
+		case d.kind == "method" && d.recv == typeName:
+			lines = append(lines, fmt.Sprintf("%s:%d: %s", d.file, d.line, d.signature))
+		case d.kind == "interface" && d.name == typeName:
+			for _, m := range d.methods {
+				lines = append(lines, fmt.Sprintf("%s:%d: %s (interface method)", d.file, m.line, m.signature))
+			}
+			for _, embed := range d.embeds {
+				lines = append(lines, fmt.Sprintf("%s:%d: embeds %s", d.file, d.line, embed))
+			}
+		case d.kind == "type" && d.name == typeName:
+			for _, embed := range d.embeds {
+				lines = append(lines, fmt.Sprintf("%s:%d: embeds %s, whose methods are promoted", d.file, d.line, embed))
+			}
+		}
+	}
+	return lines
+}
+
+func symbolCallers(calls []goCall, name string) []string {
+	// A qualifier cannot be checked without type information
+	if _, member, ok := strings.Cut(name, "."); ok {
+		name = member
+	}
+
+	var matches []goCall
+	for _, call := range calls {
+		if call.callee == name {
+			matches = append(matches, call)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].file != matches[j].file {
+			return matches[i].file < matches[j].file
+		}
+		return matches[i].line < matches[j].line
+	})
+
+	var lines []string
+	for _, call := range matches {
+		caller := call.caller
+		if caller == "" {
+			caller = "package scope"
+		}
+		text := call.text
+		if runes := []rune(text); len(runes) > maxCallerLineLength {
+			text = string(runes[:maxCallerLineLength]) + "…"
+		}
+		lines = append(lines, fmt.Sprintf("%s:%d: in %s: %s", call.file, call.line, caller, text))
+	}
+	return lines
+}
+
+func symbolImplementations(decls []goDecl, name string) ([]string, error) {
+	var ifaces []goDecl
+	for _, d := range matchDecls(decls, name) {
+		if d.kind == "interface" {
+			ifaces = append(ifaces, d)
+		}
+	}
+	if len(ifaces) == 0 {
+		return nil, fmt.Errorf("no interface named %s in the workspace", name)
+	}
+
+	// Types and methods are keyed by package directory and name
+	types := map[string]goDecl{}
+	methods := map[string][]goDecl{}
+	for _, d := range decls {
+		dir := path.Dir(d.file)
+		switch d.kind {
+		case "type", "interface":
+			types[dir+"."+d.name] = d
+		case "method":
+			methods[dir+"."+d.recv] = append(methods[dir+"."+d.recv], d)
+		}
+	}
+
+	var lines []string
+	for _, iface := range ifaces {
+		dir := path.Dir(iface.file)
+		required, unresolved := interfaceMethods(types, dir, iface, map[string]bool{})
+		// Methods of interfaces outside the workspace are unknown, so the
+		// result can only be partial
+		for _, embed := range unresolved {
+			lines = append(lines, fmt.Sprintf("note: %s embeds %s, which is not in the workspace; its methods are not checked, so this result is partial and may list types that do not implement %s", iface.name, embed, iface.name))
+		}
+		if len(required) == 0 && len(unresolved) > 0 {
+			lines = append(lines, fmt.Sprintf("note: all methods of %s come from interfaces outside the workspace, so its implementations cannot be listed", iface.name))
+			continue
+		}
+		if len(required) == 0 {
+			lines = append(lines, fmt.Sprintf("note: %s has no methods, every type implements it", iface.name))
+			continue
+		}
+
+		for _, d := range decls {
+			if d.kind != "type" {
+				continue
+			}
+			set := methodSet(types, methods, path.Dir(d.file), d.name, map[string]bool{})
+			pointer := false
+			implements := true
+			for _, m := range required {
+				have, ok := set[m.name]
+				if !ok || have.params != m.params || have.results != m.results {
+					implements = false
+					break
+				}
+				pointer = pointer || have.pointer
+			}
+			if !implements {
+				continue
+			}
+
+			via := d.name
+			if pointer {
+				via = "*" + d.name
+			}
+			lines = append(lines, fmt.Sprintf("%s:%d: %s implements %s.%s (as %s)", d.file, d.line, d.signature, iface.pkg, iface.name, via))
+		}
+	}
+	return lines, nil
+}
+
+// interfaceMethods returns the methods of an interface including those of
+// embedded interfaces, and the embedded interfaces it could not resolve.
+func interfaceMethods(types map[string]goDecl, dir string, iface goDecl, seen map[string]bool) ([]goMethod, []string) {
+	if seen[dir+"."+iface.name] {
+		return nil, nil
+	}
+	seen[dir+"."+iface.name] = true
+
+	methods := append([]goMethod(nil), iface.methods...)
+	var unresolved []string
+	for _, embed := range iface.embeds {
+		inner, ok := types[dir+"."+embed]
+		if !ok || inner.kind != "interface" {
+			unresolved = append(unresolved, embed)
+			continue
+		}
+		more, missing := interfaceMethods(types, dir, inner, seen)
+		methods = append(methods, more...)
+		unresolved = append(unresolved, missing...)
+	}
+	return methods, unresolved
+}
+
+// methodSet returns the methods of a type by name, including those promoted
+// from embedded types of the same package.
+func methodSet(types map[string]goDecl, methods map[string][]goDecl, dir, name string, seen map[string]bool) map[string]goDecl {
+	set := map[string]goDecl{}
+	key := dir + "." + name
+	if seen[key] {
+		return set
+	}
+	seen[key] = true
+
+	if d, ok := types[key]; ok {
+		for _, embed := range d.embeds {
+			for methodName, m := range methodSet(types, methods, dir, embed, seen) {
+				set[methodName] = m
+			}
+		}
+	}
+	for _, m := range methods[key] {
+		set[m.name] = m
+	}
+	return set
+}
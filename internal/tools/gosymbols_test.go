@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGoSymbolsImplementationsWithExternalEmbeds(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod": "module example\n",
+		"source.go": `package example
+
+import "io"
+
+type Source interface {
+	io.Reader
+	Name() string
+}
+
+type Stream interface {
+	io.ReadCloser
+}
+
+type File struct{}
+
+func (f *File) Read(p []byte) (int, error) { return 0, nil }
+func (f *File) Name() string               { return "" }
+
+type Label struct{}
+
+func (Label) Name() string { return "" }
+`,
+	})
+	env := &Env{WorkspaceRoot: root, Workspace: NewWorkspace(root), Logger: nopLogger{}}
+
+	query := func(name string) string {
+		input, _ := json.Marshal(GoSymbolsInput{Query: "implementations", Name: name})
+		out, err := GoSymbols(context.Background(), env, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	out := query("Source")
+	if !strings.Contains(out, "embeds io.Reader") || !strings.Contains(out, "partial") {
+		t.Errorf("Source result is not marked partial:\n%s", out)
+	}
+	if !strings.Contains(out, "implements example.Source (as *File)") {
+		t.Errorf("File is missing:\n%s", out)
+	}
+
+	out = query("Stream")
+	if strings.Contains(out, "every type implements it") || strings.Contains(out, " implements example.Stream") {
+		t.Errorf("Stream lists implementations although its methods are unknown:\n%s", out)
+	}
+	if !strings.Contains(out, "embeds io.ReadCloser") {
+		t.Errorf("Stream does not report the external embed:\n%s", out)
+	}
+}
@@ -0,0 +1,189 @@
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gocopilot/internal/glob"
+)
+
+// ignoreFiles are read in every directory, in this order, so .ignore can
+// re-include what .gitignore excludes.
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// alwaysIgnored directories are never listed or searched.
+var alwaysIgnored = map[string]bool{".git": true}
+
+type ignoreRule struct {
+	// base is the directory of the ignore file, relative to the walk root
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreMatcher applies gitignore rules collected while walking a tree.
+type ignoreMatcher struct {
+	root  string
+	rules []ignoreRule
+	// repos are the directories below the root that are git repositories,
+	// or "." when the root is inside one. As with git and rg, .gitignore
+	// files only apply inside a repository.
+	repos []string
+}
+
+func newIgnoreMatcher(root string) *ignoreMatcher {
+	m := &ignoreMatcher{root: root}
+	for dir := filepath.Dir(root); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			m.repos = []string{"."}
+			break
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return m
+}
+
+// workspaceIgnoreMatcher returns a matcher for walking dir. Ignore files apply
+// from the workspace root down, even when dir is a subdirectory; paths passed
+// to the matcher must then be prefixed with the returned prefix.
+func workspaceIgnoreMatcher(workspace *Workspace, dir string) (*ignoreMatcher, string) {
+	ignoreRoot, prefix := dir, ""
+	if rel := workspace.Rel(dir); rel != dir && rel != "." {
+		ignoreRoot, prefix = workspace.Root(), rel
+	}
+
+	matcher := newIgnoreMatcher(ignoreRoot)
+	matcher.loadDir("")
+	if prefix != "" {
+		parts := strings.Split(prefix, "/")
+		for i := range parts {
+			matcher.loadDir(strings.Join(parts[:i+1], "/"))
+		}
+	}
+	return matcher, prefix
+}
+
+// loadDir reads the ignore files of a directory relative to the root.
+func (m *ignoreMatcher) loadDir(relDir string) {
+	dir := filepath.Join(m.root, filepath.FromSlash(relDir))
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil && !m.inRepo(relDir) {
+		m.repos = append(m.repos, path.Clean(relDir))
+	}
+
+	for _, name := range ignoreFiles {
+		if name == ".gitignore" && !m.inRepo(relDir) {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreLine(relDir, scanner.Text()); ok {
+				m.rules = append(m.rules, rule)
+			}
+		}
+		file.Close()
+	}
+}
+
+func (m *ignoreMatcher) inRepo(relDir string) bool {
+	relDir = path.Clean(relDir)
+	for _, repo := range m.repos {
+		if repo == "." || relDir == repo || strings.HasPrefix(relDir, repo+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored reports whether a slash separated path relative to the root is
+// excluded. The last matching rule wins, as in git.
+func (m *ignoreMatcher) ignored(relPath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		rel := relPath
+		if rule.base != "" && rule.base != "." {
+			if !strings.HasPrefix(relPath, rule.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(relPath, rule.base+"/")
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func parseIgnoreLine(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, "\\")
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// Patterns with a slash other than at the end are relative to the
+	// ignore file; others match a name at any depth.
+	prefix := "^(.*/)?"
+	if strings.Contains(line, "/") {
+		prefix = "^"
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	re, err := regexp.Compile(prefix + glob.ToRegexp(line) + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globMatcher matches slash separated paths against a glob. Globs without a
+// slash are matched against the last path element only.
+type globMatcher struct {
+	re       *regexp.Regexp
+	baseName bool
+}
+
+func newGlobMatcher(pattern string) (*globMatcher, error) {
+	re, err := glob.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+	}
+	return &globMatcher{re: re, baseName: !strings.Contains(pattern, "/")}, nil
+}
+
+func (g *globMatcher) Match(relPath string) bool {
+	if g.baseName {
+		relPath = path.Base(relPath)
+	}
+	return g.re.MatchString(relPath)
+}
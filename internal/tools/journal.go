@@ -0,0 +1,227 @@
+package tools
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Change is a file modification made by a tool call. A nil Before means the
+// call created the file and a nil After means it deleted it.
+type Change struct {
+	ID     int
+	Turn   int
+	CallID string
+	// Path is the absolute path of the file, Name the path shown to the user.
+	Path   string
+	Name   string
+	Before *string
+	After  *string
+	// Mode is the permission bits the file had before the change, or 0 if
+	// unknown; undoing a deletion recreates the file with them.
+	Mode fs.FileMode
+	Time time.Time
+}
+
+// Kind returns A, D or M for a created, deleted or modified file.
+func (c Change) Kind() string {
+	switch {
+	case c.Before == nil:
+		return "A"
+	case c.After == nil:
+		return "D"
+	default:
+		return "M"
+	}
+}
+
+// Diff returns the change as a unified diff.
+func (c Change) Diff() string {
+	oldName, newName := "a/"+c.Name, "b/"+c.Name
+	if c.Before == nil {
+		oldName = "/dev/null"
+	}
+	if c.After == nil {
+		newName = "/dev/null"
+	}
+	return UnifiedDiff(oldName, newName, deref(c.Before), deref(c.After))
+}
+
+// Stat returns the number of lines the change added and removed.
+func (c Change) Stat() (added, removed int) {
+	for _, op := range diffLines(splitLines(deref(c.Before)), splitLines(deref(c.After))) {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// Journal records the file changes tools make during a session so they can
+// be reviewed and undone. Changes made by shell commands are not recorded.
+type Journal struct {
+	mu      sync.Mutex
+	changes []Change
+	nextID  int
+	turn    int
+}
+
+func NewJournal() *Journal {
+	return &Journal{nextID: 1}
+}
+
+// BeginTurn starts a new turn; changes recorded from now on belong to it.
+func (j *Journal) BeginTurn() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.turn++
+	return j.turn
+}
+
+// Record adds a change made by the tool call callID.
+func (j *Journal) Record(callID, path, name string, before, after *string, mode fs.FileMode) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.changes = append(j.changes, Change{
+		ID:     j.nextID,
+		Turn:   j.turn,
+		CallID: callID,
+		Path:   path,
+		Name:   name,
+		Before: before,
+		After:  after,
+		Mode:   mode,
+		Time:   time.Now(),
+	})
+	j.nextID++
+}
+
+// Changes returns the recorded changes, oldest first.
+func (j *Journal) Changes() []Change {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return append([]Change(nil), j.changes...)
+}
+
+// Get returns the change with the given ID.
+func (j *Journal) Get(id int) (Change, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, change := range j.changes {
+		if change.ID == id {
+			return change, true
+		}
+	}
+	return Change{}, false
+}
+
+// Reset forgets all changes, e.g. when a new session starts.
+func (j *Journal) Reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.changes = nil
+	j.nextID = 1
+	j.turn = 0
+}
+
+// UndoLast reverts the n most recent changes, newest first.
+func (j *Journal) UndoLast(n int) ([]Change, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	n = min(n, len(j.changes))
+	return j.undo(j.changes[len(j.changes)-n:])
+}
+
+// UndoTurn reverts the changes made during a turn, newest first.
+func (j *Journal) UndoTurn(turn int) ([]Change, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var selected []Change
+	for _, change := range j.changes {
+		if change.Turn == turn {
+			selected = append(selected, change)
+		}
+	}
+	return j.undo(selected)
+}
+
+// undo restores the files of the given changes in reverse order and drops
+// them from the journal. It stops at the first file that was modified again
+// since the change, so later edits are never overwritten.
+func (j *Journal) undo(selected []Change) ([]Change, error) {
+	selected = append([]Change(nil), selected...)
+
+	var undone []Change
+	var err error
+	for i := len(selected) - 1; i >= 0; i-- {
+		change := selected[i]
+		if err = restoreChange(change); err != nil {
+			break
+		}
+		undone = append(undone, change)
+	}
+
+	kept := j.changes[:0]
+	for _, change := range j.changes {
+		reverted := false
+		for _, u := range undone {
+			if u.ID == change.ID {
+				reverted = true
+				break
+			}
+		}
+		if !reverted {
+			kept = append(kept, change)
+		}
+	}
+	j.changes = kept
+	return undone, err
+}
+
+func restoreChange(change Change) error {
+	data, err := os.ReadFile(change.Path)
+	switch {
+	case err == nil:
+		if change.After == nil || string(data) != *change.After {
+			return fmt.Errorf("%s was modified after change #%d, not undoing it", change.Name, change.ID)
+		}
+	case os.IsNotExist(err):
+		if change.After != nil {
+			return fmt.Errorf("%s was deleted after change #%d, not undoing it", change.Name, change.ID)
+		}
+	default:
+		return err
+	}
+
+	if change.Before == nil {
+		return os.Remove(change.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(change.Path), 0755); err != nil {
+		return err
+	}
+	perm := change.Mode
+	if perm == 0 {
+		perm = 0644
+	}
+	return writeFileAtomic(change.Path, []byte(*change.Before), perm)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
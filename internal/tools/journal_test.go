@@ -0,0 +1,143 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// journalEnv returns an env that records changes to a new journal.
+func journalEnv(t *testing.T, files map[string]string) (*Env, string) {
+	t.Helper()
+	env, root := patchWorkspace(t, files)
+	env.Journal = NewJournal()
+	env.Journal.BeginTurn()
+	return env, root
+}
+
+func editTestFile(t *testing.T, env *Env, path, oldStr, newStr string) {
+	t.Helper()
+	input, _ := json.Marshal(EditFileInput{Path: path, OldStr: oldStr, NewStr: newStr})
+	if _, err := EditFile(context.Background(), env, input); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func undoneIDs(changes []Change) []int {
+	var ids []int
+	for _, change := range changes {
+		ids = append(ids, change.ID)
+	}
+	return ids
+}
+
+func TestUndoRevertsChangesNewestFirst(t *testing.T) {
+	env, root := journalEnv(t, map[string]string{"a.txt": "one\n"})
+	editTestFile(t, env, "a.txt", "one", "two")
+	editTestFile(t, env, "a.txt", "two", "three")
+	editTestFile(t, env, "b.txt", "", "created\n")
+
+	undone, err := env.Journal.UndoLast(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := undoneIDs(undone); len(ids) != 3 || ids[0] != 3 || ids[1] != 2 || ids[2] != 1 {
+		t.Errorf("undone changes %v, want [3 2 1]", ids)
+	}
+	if got := readTestFile(t, root, "a.txt"); got != "one\n" {
+		t.Errorf("a.txt = %q, want the original content", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("created file was not removed: %v", err)
+	}
+	if changes := env.Journal.Changes(); len(changes) != 0 {
+		t.Errorf("%d changes left in the journal", len(changes))
+	}
+}
+
+func TestUndoStopsAtFilesModifiedLater(t *testing.T) {
+	env, root := journalEnv(t, map[string]string{"a.txt": "one\n", "b.txt": "x\n"})
+	editTestFile(t, env, "a.txt", "one", "two")
+	editTestFile(t, env, "b.txt", "x", "y")
+
+	// a.txt changes behind the journal's back, e.g. through bash
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("manual\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	undone, err := env.Journal.UndoLast(2)
+	if err == nil || !strings.Contains(err.Error(), "a.txt was modified after change #1") {
+		t.Errorf("err = %v, want a.txt reported as modified", err)
+	}
+	if ids := undoneIDs(undone); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("undone changes %v, want only [2]", ids)
+	}
+	if got := readTestFile(t, root, "b.txt"); got != "x\n" {
+		t.Errorf("b.txt = %q, want it undone", got)
+	}
+	if got := readTestFile(t, root, "a.txt"); got != "manual\n" {
+		t.Errorf("a.txt = %q, the later edit was overwritten", got)
+	}
+	if changes := env.Journal.Changes(); len(changes) != 1 || changes[0].ID != 1 {
+		t.Errorf("journal holds %v, want the change that was not undone", undoneIDs(changes))
+	}
+
+	// a file deleted since is not recreated either
+	editTestFile(t, env, "c.txt", "", "new\n")
+	if err := os.Remove(filepath.Join(root, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Journal.UndoLast(1); err == nil || !strings.Contains(err.Error(), "was deleted after") {
+		t.Errorf("err = %v, want c.txt reported as deleted", err)
+	}
+}
+
+func TestUndoDeletionRestoresContentAndMode(t *testing.T) {
+	env, root := journalEnv(t, map[string]string{"run.sh": "#!/bin/sh\nexit 0\n"})
+	if err := os.Chmod(filepath.Join(root, "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := "--- a/run.sh\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-#!/bin/sh\n-exit 0\n"
+	if _, err := applyTestPatch(env, patch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "run.sh")); !os.IsNotExist(err) {
+		t.Fatalf("run.sh was not deleted: %v", err)
+	}
+
+	if _, err := env.Journal.UndoLast(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, root, "run.sh"); got != "#!/bin/sh\nexit 0\n" {
+		t.Errorf("run.sh = %q", got)
+	}
+	if mode := fileMode(t, root, "run.sh"); mode != 0755 {
+		t.Errorf("run.sh mode = %v, want 0755", mode)
+	}
+}
+
+func TestUndoTurnOnlyRevertsThatTurn(t *testing.T) {
+	env, root := journalEnv(t, map[string]string{"a.txt": "one\n"})
+	editTestFile(t, env, "a.txt", "one", "two")
+	turn := env.Journal.BeginTurn()
+	editTestFile(t, env, "b.txt", "", "b\n")
+	editTestFile(t, env, "a.txt", "two", "three")
+
+	undone, err := env.Journal.UndoTurn(turn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := undoneIDs(undone); len(ids) != 2 || ids[0] != 3 || ids[1] != 2 {
+		t.Errorf("undone changes %v, want [3 2]", ids)
+	}
+	if got := readTestFile(t, root, "a.txt"); got != "two\n" {
+		t.Errorf("a.txt = %q, want the first turn's edit kept", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("b.txt was not removed: %v", err)
+	}
+}
@@ -0,0 +1,577 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPatchFuzz is the number of context lines a hunk may drop at either end
+// when it does not match as given.
+const maxPatchFuzz = 2
+
+const devNull = "/dev/null"
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// filePatch is the part of a patch for one file. An empty oldPath creates the
+// file and an empty newPath deletes it.
+type filePatch struct {
+	oldPath    string
+	newPath    string
+	renameFrom string
+	renameTo   string
+	hunks      []patchHunk
+
+	// sawHeaders is set once the ---/+++ lines of the file were read
+	sawHeaders bool
+}
+
+type patchHunk struct {
+	header string
+	// oldStart is the 1-based line the hunk starts at, or 0 if unknown
+	oldStart int
+	oldCount int
+	ops      []diffOp
+}
+
+// parsePatch splits a unified diff into per-file patches. Lines outside file
+// headers and hunks, such as git index lines or code fences, are ignored.
+func parsePatch(text string) ([]*filePatch, error) {
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	lines := splitLines(text)
+
+	var patches []*filePatch
+	var cur *filePatch
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			cur = &filePatch{}
+			cur.oldPath, cur.newPath = parseGitHeader(strings.TrimPrefix(line, "diff --git "))
+			patches = append(patches, cur)
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if cur == nil || cur.sawHeaders || len(cur.hunks) > 0 {
+				cur = &filePatch{}
+				patches = append(patches, cur)
+			}
+			cur.oldPath = parsePatchPath(line[4:])
+			cur.newPath = parsePatchPath(strings.TrimRight(lines[i+1], "\r\n")[4:])
+			cur.sawHeaders = true
+			i++
+		case cur != nil && strings.HasPrefix(line, "new file mode "):
+			cur.oldPath = devNull
+		case cur != nil && strings.HasPrefix(line, "deleted file mode "):
+			cur.newPath = devNull
+		case cur != nil && strings.HasPrefix(line, "rename from "):
+			cur.renameFrom = strings.TrimPrefix(line, "rename from ")
+		case cur != nil && strings.HasPrefix(line, "rename to "):
+			cur.renameTo = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "@@"):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk without a file header", i+1)
+			}
+			hunk, next := parseHunk(lines, i)
+			cur.hunks = append(cur.hunks, hunk)
+			i = next - 1
+		}
+	}
+
+	for _, p := range patches {
+		if (p.oldPath == devNull || strings.HasPrefix(p.oldPath, "a/")) &&
+			(p.newPath == devNull || strings.HasPrefix(p.newPath, "b/")) {
+			p.oldPath = strings.TrimPrefix(p.oldPath, "a/")
+			p.newPath = strings.TrimPrefix(p.newPath, "b/")
+		}
+		if p.renameFrom != "" && p.renameTo != "" {
+			p.oldPath, p.newPath = p.renameFrom, p.renameTo
+		}
+		if p.oldPath == devNull {
+			p.oldPath = ""
+		}
+		if p.newPath == devNull {
+			p.newPath = ""
+		}
+		if p.oldPath == "" && p.newPath == "" {
+			return nil, fmt.Errorf("patch is missing file names")
+		}
+	}
+	return patches, nil
+}
+
+// parseGitHeader splits the "a/old b/new" part of a diff --git line.
+func parseGitHeader(rest string) (string, string) {
+	if i := strings.Index(rest, " b/"); strings.HasPrefix(rest, "a/") && i > 0 {
+		return rest[:i], rest[i+1:]
+	}
+	if fields := strings.Fields(rest); len(fields) == 2 {
+		return fields[0], fields[1]
+	}
+	return "", ""
+}
+
+// parsePatchPath returns the file name of a ---/+++ line without a trailing
+// timestamp.
+func parsePatchPath(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	return s
+}
+
+// parseHunk reads the hunk starting at lines[start] and returns it with the
+// index of the first line after it. Line counts in the header are not
+// trusted; the hunk ends at the first line that cannot belong to it.
+func parseHunk(lines []string, start int) (patchHunk, int) {
+	header := strings.TrimRight(lines[start], "\r\n")
+	hunk := patchHunk{header: header}
+	if m := hunkHeader.FindStringSubmatch(header); m != nil {
+		hunk.oldStart, _ = strconv.Atoi(m[1])
+		hunk.oldCount = 1
+		if m[2] != "" {
+			hunk.oldCount, _ = strconv.Atoi(m[2])
+		}
+	}
+
+	// Blank lines are taken as empty context lines, as editors often strip
+	// the leading space, except at the end of the hunk
+	blank := 0
+	i := start + 1
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "diff --git ") ||
+			strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			break
+		}
+
+		switch line[0] {
+		case ' ', '-', '+':
+			hunk.ops = append(hunk.ops, diffOp{kind: line[0], line: line[1:]})
+			blank = 0
+			continue
+		case '\\':
+			if n := len(hunk.ops); n > 0 {
+				last := strings.TrimSuffix(hunk.ops[n-1].line, "\n")
+				hunk.ops[n-1].line = strings.TrimSuffix(last, "\r")
+			}
+			continue
+		case '\n', '\r':
+			if strings.TrimRight(line, "\r\n") == "" {
+				hunk.ops = append(hunk.ops, diffOp{kind: ' ', line: line})
+				blank++
+				continue
+			}
+		}
+		break
+	}
+	hunk.ops = hunk.ops[:len(hunk.ops)-blank]
+	return hunk, i - blank
+}
+
+// patchPaths returns the files a patch touches, for permission rules.
+func patchPaths(text string) []string {
+	patches, err := parsePatch(text)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, p := range patches {
+		for _, name := range []string{p.oldPath, p.newPath} {
+			if name != "" && (len(paths) == 0 || paths[len(paths)-1] != name) {
+				paths = append(paths, name)
+			}
+		}
+	}
+	return paths
+}
+
+// applyHunks applies hunks in order to content. It returns one line per hunk
+// describing where it applied, and false if any of them did not apply.
+func applyHunks(content string, hunks []patchHunk) (string, []string, bool) {
+	lines := splitLines(content)
+	eol := "\n"
+	if len(lines) > 0 && strings.HasSuffix(lines[0], "\r\n") {
+		eol = "\r\n"
+	}
+
+	var out, results []string
+	ok := true
+	pos, drift := 0, 0
+	for n, hunk := range hunks {
+		expected := pos
+		if hunk.oldStart > 0 {
+			expected = hunk.oldStart - 1 + drift
+			if hunk.oldCount == 0 {
+				expected++
+			}
+		}
+
+		match, found := locateHunk(lines, pos, expected, hunk.ops)
+		if !found {
+			results = append(results, fmt.Sprintf("hunk %d %s: FAILED, lines to change not found", n+1, hunk.header))
+			ok = false
+			continue
+		}
+
+		out = append(out, lines[pos:match.at]...)
+		k := match.at
+		for _, op := range match.ops {
+			switch op.kind {
+			case ' ':
+				out = append(out, lines[k])
+				k++
+			case '-':
+				k++
+			case '+':
+				line := op.line
+				if eol == "\r\n" && strings.HasSuffix(line, "\n") && !strings.HasSuffix(line, "\r\n") {
+					line = strings.TrimSuffix(line, "\n") + eol
+				}
+				out = append(out, line)
+			}
+		}
+
+		note := match.note
+		if hunk.oldStart > 0 && match.start != expected {
+			note = append([]string{fmt.Sprintf("offset %+d lines", match.start-expected)}, note...)
+			drift += match.start - expected
+		}
+		result := fmt.Sprintf("hunk %d %s: applied at line %d", n+1, hunk.header, match.at+1)
+		if len(note) > 0 {
+			result += " (" + strings.Join(note, ", ") + ")"
+		}
+		results = append(results, result)
+		pos = k
+	}
+	out = append(out, lines[pos:]...)
+
+	// A line that lost its newline by matching a file's last line loosely
+	// must not be joined with the next one
+	var b strings.Builder
+	for i, line := range out {
+		b.WriteString(line)
+		if i < len(out)-1 && !strings.HasSuffix(line, "\n") {
+			b.WriteString(eol)
+		}
+	}
+	return b.String(), results, ok
+}
+
+// hunkMatch is where a hunk applies.
+type hunkMatch struct {
+	// start is where the old side of the whole hunk begins and at is where
+	// ops apply; they differ by the leading context lines dropped as fuzz
+	start int
+	at    int
+	ops   []diffOp
+	// note describes any fuzz used
+	note []string
+}
+
+// locateHunk finds where the old side of a hunk is in lines, at or after pos
+// and as close to expected as possible. Exact matches are preferred over
+// matches that ignore whitespace and over matches that drop context lines at
+// either end.
+func locateHunk(lines []string, pos, expected int, ops []diffOp) (hunkMatch, bool) {
+	compare := []func(a, b string) bool{
+		func(a, b string) bool { return a == b },
+		func(a, b string) bool { return strings.TrimRight(a, " \t\r\n") == strings.TrimRight(b, " \t\r\n") },
+		func(a, b string) bool { return strings.TrimSpace(a) == strings.TrimSpace(b) },
+	}
+
+	for fuzz := 0; fuzz <= maxPatchFuzz; fuzz++ {
+		lead, trail := 0, 0
+		for lead < fuzz && lead < len(ops) && ops[lead].kind == ' ' {
+			lead++
+		}
+		for trail < fuzz && trail < len(ops)-lead && ops[len(ops)-1-trail].kind == ' ' {
+			trail++
+		}
+		if fuzz > 0 && lead+trail == 0 {
+			continue
+		}
+		trimmed := ops[lead : len(ops)-trail]
+
+		var old []string
+		for _, op := range trimmed {
+			if op.kind != '+' {
+				old = append(old, op.line)
+			}
+		}
+		if len(old) == 0 {
+			// Pure insertions only apply where the hunk says
+			if fuzz > 0 {
+				continue
+			}
+			at := min(max(expected, pos), len(lines))
+			return hunkMatch{start: at, at: at, ops: trimmed}, true
+		}
+
+		for level, equal := range compare {
+			at, found := searchLines(lines, old, pos, expected+lead, equal)
+			if !found {
+				continue
+			}
+			var note []string
+			if level > 0 {
+				note = append(note, "ignoring whitespace")
+			}
+			if fuzz > 0 {
+				note = append(note, fmt.Sprintf("fuzz %d", fuzz))
+			}
+			return hunkMatch{start: at - lead, at: at, ops: trimmed, note: note}, true
+		}
+	}
+	return hunkMatch{}, false
+}
+
+// searchLines looks for want in lines starting no earlier than pos, trying
+// positions in order of distance from expected.
+func searchLines(lines, want []string, pos, expected int, equal func(a, b string) bool) (int, bool) {
+	last := len(lines) - len(want)
+	if last < pos {
+		return 0, false
+	}
+	expected = min(max(expected, pos), last)
+
+	matches := func(at int) bool {
+		for i, line := range want {
+			if !equal(lines[at+i], line) {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; expected-d >= pos || expected+d <= last; d++ {
+		if at := expected - d; at >= pos && matches(at) {
+			return at, true
+		}
+		if at := expected + d; d > 0 && at <= last && matches(at) {
+			return at, true
+		}
+	}
+	return 0, false
+}
+
+// patchTree holds the planned contents of the files a patch touches, so later
+// parts of a patch see the changes of earlier ones and nothing is written
+// until every part applies.
+type patchTree struct {
+	files map[string]*string
+	perms map[string]fs.FileMode
+	order []string
+}
+
+func newPatchTree() *patchTree {
+	return &patchTree{files: map[string]*string{}, perms: map[string]fs.FileMode{}}
+}
+
+func (t *patchTree) read(path string) (string, bool, error) {
+	if content, ok := t.files[path]; ok {
+		if content == nil {
+			return "", false, nil
+		}
+		return *content, true, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+// set plans the content of a file; nil deletes it.
+func (t *patchTree) set(path string, content *string) {
+	if _, ok := t.files[path]; !ok {
+		t.order = append(t.order, path)
+	}
+	t.files[path] = content
+}
+
+// commit writes the planned changes and reports each one to record. If one
+// fails, the files already changed are restored.
+func (t *patchTree) commit(record func(path string, before, after *string, mode fs.FileMode)) error {
+	type backup struct {
+		path    string
+		data    []byte
+		perm    fs.FileMode
+		existed bool
+	}
+	var done []backup
+
+	restore := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			b := done[i]
+			if b.existed {
+				_ = writeFileAtomic(b.path, b.data, b.perm)
+			} else {
+				_ = os.Remove(b.path)
+			}
+		}
+	}
+
+	for _, path := range t.order {
+		data, err := os.ReadFile(path)
+		existed := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			restore()
+			return err
+		}
+		var oldPerm fs.FileMode = 0644
+		if info, err := os.Stat(path); err == nil {
+			oldPerm = info.Mode().Perm()
+		}
+
+		content := t.files[path]
+		switch {
+		case content == nil && existed:
+			err = os.Remove(path)
+		case content != nil:
+			perm, ok := t.perms[path]
+			if !ok {
+				perm = 0644
+			}
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+				err = writeFileAtomic(path, []byte(*content), perm)
+			}
+		}
+		if err != nil {
+			restore()
+			return err
+		}
+		done = append(done, backup{path: path, data: data, perm: oldPerm, existed: existed})
+	}
+
+	for _, b := range done {
+		var before *string
+		var mode fs.FileMode
+		if b.existed {
+			data := string(b.data)
+			before, mode = &data, b.perm
+		}
+		if before != nil || t.files[b.path] != nil {
+			record(b.path, before, t.files[b.path], mode)
+		}
+	}
+	return nil
+}
+
+func ApplyPatch(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+	log := env.Logger
+	applyPatchInput := ApplyPatchInput{}
+	err := json.Unmarshal(input, &applyPatchInput)
+	if err != nil {
+		return "", err
+	}
+
+	patches, err := parsePatch(applyPatchInput.Patch)
+	if err != nil {
+		return "", fmt.Errorf("invalid patch: %w", err)
+	}
+	if len(patches) == 0 {
+		return "", fmt.Errorf("invalid patch: no file headers found")
+	}
+
+	tree := newPatchTree()
+	var report strings.Builder
+	failed := false
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(&report, "  FAILED: "+format+"\n", args...)
+		failed = true
+	}
+
+	for _, p := range patches {
+		var oldPath, newPath string
+		if p.oldPath != "" {
+			if oldPath, err = env.Workspace.ResolveWrite(p.oldPath); err != nil {
+				return "", err
+			}
+		}
+		if p.newPath != "" {
+			if newPath, err = env.Workspace.ResolveWrite(p.newPath); err != nil {
+				return "", err
+			}
+		}
+
+		switch {
+		case oldPath == "":
+			fmt.Fprintf(&report, "A %s\n", env.Workspace.Rel(newPath))
+		case newPath == "":
+			fmt.Fprintf(&report, "D %s\n", env.Workspace.Rel(oldPath))
+		case oldPath != newPath:
+			fmt.Fprintf(&report, "R %s -> %s\n", env.Workspace.Rel(oldPath), env.Workspace.Rel(newPath))
+		default:
+			fmt.Fprintf(&report, "M %s\n", env.Workspace.Rel(newPath))
+		}
+
+		content := ""
+		if oldPath != "" {
+			var exists bool
+			content, exists, err = tree.read(oldPath)
+			if err != nil {
+				return "", err
+			}
+			if !exists {
+				fail("file does not exist")
+				continue
+			}
+		}
+		if newPath != "" && newPath != oldPath {
+			if _, exists, err := tree.read(newPath); err != nil {
+				return "", err
+			} else if exists {
+				fail("file already exists")
+				continue
+			}
+		}
+
+		updated, results, ok := applyHunks(content, p.hunks)
+		for _, result := range results {
+			fmt.Fprintf(&report, "  %s\n", result)
+		}
+		if !ok {
+			failed = true
+			continue
+		}
+
+		if oldPath != "" && oldPath != newPath {
+			if info, err := os.Stat(oldPath); err == nil {
+				tree.perms[newPath] = info.Mode().Perm()
+			}
+			tree.set(oldPath, nil)
+		}
+		if newPath != "" {
+			tree.set(newPath, &updated)
+		}
+	}
+
+	if failed {
+		log.Error("ApplyPatch failed:\n%s", report.String())
+		return "", fmt.Errorf("patch was not applied, no files were changed:\n%s", report.String())
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := tree.commit(env.recordChange); err != nil {
+		log.Error("Failed to write patch: %v", err)
+		return "", fmt.Errorf("failed to write patch, no files were changed: %w", err)
+	}
+
+	log.Debug("Applied patch to %d files", len(tree.order))
+	return "Patch applied:\n" + report.String(), nil
+}
@@ -0,0 +1,262 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// patchWorkspace returns an env for a workspace holding files.
+func patchWorkspace(t *testing.T, files map[string]string) (*Env, string) {
+	t.Helper()
+	root := t.TempDir()
+	writeFiles(t, root, files)
+	return &Env{WorkspaceRoot: root, Workspace: NewWorkspace(root), Logger: nopLogger{}}, root
+}
+
+func applyTestPatch(env *Env, patch string) (string, error) {
+	input, _ := json.Marshal(ApplyPatchInput{Patch: patch})
+	return ApplyPatch(context.Background(), env, input)
+}
+
+func readTestFile(t *testing.T, root, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func fileMode(t *testing.T, root, name string) os.FileMode {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestApplyPatchFileOperations(t *testing.T) {
+	env, root := patchWorkspace(t, map[string]string{
+		"run.sh":   "#!/bin/sh\necho one\necho two\n",
+		"old.txt":  "unused\n",
+		"tool.sh":  "#!/bin/sh\nexit 0\n",
+		"keep.txt": "keep\n",
+	})
+	for _, name := range []string{"run.sh", "tool.sh"} {
+		if err := os.Chmod(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	patch := `diff --git a/run.sh b/run.sh
+--- a/run.sh
++++ b/run.sh
+@@ -1,3 +1,3 @@
+ #!/bin/sh
+-echo one
++echo 1
+ echo two
+diff --git a/new/notes.md b/new/notes.md
+new file mode 100644
+--- /dev/null
++++ b/new/notes.md
+@@ -0,0 +1,2 @@
++# Notes
++created
+diff --git a/old.txt b/old.txt
+deleted file mode 100644
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-unused
+diff --git a/tool.sh b/bin/tool.sh
+similarity index 80%
+rename from tool.sh
+rename to bin/tool.sh
+--- a/tool.sh
++++ b/bin/tool.sh
+@@ -1,2 +1,2 @@
+ #!/bin/sh
+-exit 0
++exit 1
+`
+	out, err := applyTestPatch(env, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"M run.sh", "A new/notes.md", "D old.txt", "R tool.sh -> bin/tool.sh"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report is missing %q:\n%s", want, out)
+		}
+	}
+
+	if got := readTestFile(t, root, "run.sh"); got != "#!/bin/sh\necho 1\necho two\n" {
+		t.Errorf("run.sh = %q", got)
+	}
+	if mode := fileMode(t, root, "run.sh"); mode != 0755 {
+		t.Errorf("run.sh mode = %v, want 0755", mode)
+	}
+	if got := readTestFile(t, root, "new/notes.md"); got != "# Notes\ncreated\n" {
+		t.Errorf("new/notes.md = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("old.txt was not deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "tool.sh")); !os.IsNotExist(err) {
+		t.Errorf("tool.sh still exists after the rename: %v", err)
+	}
+	if got := readTestFile(t, root, "bin/tool.sh"); got != "#!/bin/sh\nexit 1\n" {
+		t.Errorf("bin/tool.sh = %q", got)
+	}
+	if mode := fileMode(t, root, "bin/tool.sh"); mode != 0755 {
+		t.Errorf("bin/tool.sh mode = %v, want 0755", mode)
+	}
+}
+
+func TestApplyPatchIsAllOrNothing(t *testing.T) {
+	env, root := patchWorkspace(t, map[string]string{
+		"a.txt": "one\ntwo\n",
+		"b.txt": "three\nfour\n",
+	})
+
+	patch := `--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+-one
++ONE
+ two
+--- a/b.txt
++++ b/b.txt
+@@ -1,2 +1,2 @@
+-five
++FIVE
+ four
+--- /dev/null
++++ b/c.txt
+@@ -0,0 +1 @@
++new
+`
+	out, err := applyTestPatch(env, patch)
+	if err == nil {
+		t.Fatalf("patch applied:\n%s", out)
+	}
+	if !strings.Contains(err.Error(), "hunk 1 @@ -1,2 +1,2 @@: FAILED") {
+		t.Errorf("error does not name the failed hunk: %v", err)
+	}
+	if got := readTestFile(t, root, "a.txt"); got != "one\ntwo\n" {
+		t.Errorf("a.txt was changed to %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("c.txt was created: %v", err)
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		patch   string
+		want    string
+		note    string
+	}{
+		{
+			name:    "offset",
+			content: "a\nb\nc\nd\ne\nf\ng\n",
+			patch:   "@@ -2,3 +2,3 @@\n e\n-f\n+F\n g\n",
+			want:    "a\nb\nc\nd\ne\nF\ng\n",
+			note:    "offset +3 lines",
+		},
+		{
+			name:    "later hunks follow the drift of earlier ones",
+			content: "x\nx\nx\na\nb\nc\nd\ne\n",
+			patch:   "@@ -1,2 +1,2 @@\n a\n-b\n+B\n@@ -4,2 +4,2 @@\n d\n-e\n+E\n",
+			want:    "x\nx\nx\na\nB\nc\nd\nE\n",
+			note:    "hunk 2 @@ -4,2 +4,2 @@: applied at line 7",
+		},
+		{
+			name:    "context fuzz",
+			content: "one\ntwo\nthree\nfour\n",
+			patch:   "@@ -1,4 +1,4 @@\n one\n-two\n+TWO\n three\n changed context\n",
+			want:    "one\nTWO\nthree\nfour\n",
+			note:    "fuzz 1",
+		},
+		{
+			name:    "context fuzz at the start of the file",
+			content: "two\nthree\n",
+			patch:   "@@ -1,3 +1,3 @@\n zero\n-two\n+TWO\n three\n",
+			want:    "TWO\nthree\n",
+			note:    "fuzz 1",
+		},
+		{
+			name:    "whitespace fuzz",
+			content: "func f() {\n\treturn 1  \n}\n",
+			patch:   "@@ -1,3 +1,3 @@\n func f() {\n-    return 1\n+\treturn 2\n }\n",
+			want:    "func f() {\n\treturn 2\n}\n",
+			note:    "ignoring whitespace",
+		},
+		{
+			name:    "crlf file",
+			content: "one\r\ntwo\r\nthree\r\n",
+			patch:   "@@ -1,3 +1,4 @@\n one\n-two\n+TWO\n+added\n three\n",
+			want:    "one\r\nTWO\r\nadded\r\nthree\r\n",
+			note:    "ignoring whitespace",
+		},
+		{
+			name:    "crlf patch",
+			content: "one\r\ntwo\r\n",
+			patch:   "@@ -1,2 +1,2 @@\r\n one\r\n-two\r\n+TWO\r\n",
+			want:    "one\r\nTWO\r\n",
+		},
+		{
+			name:    "no newline at end of the old file",
+			content: "one\ntwo",
+			patch:   "@@ -1,2 +1,2 @@\n one\n-two\n\\ No newline at end of file\n+two\n",
+			want:    "one\ntwo\n",
+		},
+		{
+			name:    "no newline at end of the new file",
+			content: "one\ntwo\n",
+			patch:   "@@ -1,2 +1,2 @@\n one\n-two\n+TWO\n\\ No newline at end of file\n",
+			want:    "one\nTWO",
+		},
+		{
+			name:    "no newline at end of either file",
+			content: "one\ntwo",
+			patch:   "@@ -1,2 +1,2 @@\n one\n-two\n\\ No newline at end of file\n+TWO\n\\ No newline at end of file\n",
+			want:    "one\nTWO",
+		},
+	}
+
+	for _, tt := range tests {
+		patches, err := parsePatch("--- a/f\n+++ b/f\n" + tt.patch)
+		if err != nil || len(patches) != 1 {
+			t.Fatalf("%s: parsePatch() = %d patches, %v", tt.name, len(patches), err)
+		}
+		got, results, ok := applyHunks(tt.content, patches[0].hunks)
+		if !ok {
+			t.Errorf("%s: did not apply: %q", tt.name, results)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if report := strings.Join(results, "\n"); !strings.Contains(report, tt.note) {
+			t.Errorf("%s: results %q do not mention %q", tt.name, report, tt.note)
+		}
+	}
+}
+
+func TestApplyHunksRejectsMissingLines(t *testing.T) {
+	patches, err := parsePatch("--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, results, ok := applyHunks("one\n2\nthree\n", patches[0].hunks); ok {
+		t.Errorf("applied to unrelated content: %q, %q", got, results)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package tools
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// configureCommand runs the command in its own process group so cancelling
+// it also stops any children it spawned, not just the shell itself.
+func configureCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+}
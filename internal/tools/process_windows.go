@@ -0,0 +1,14 @@
+//go:build windows
+
+package tools
+
+import (
+	"os/exec"
+	"time"
+)
+
+// configureCommand bounds how long a cancelled command may keep its output
+// pipes open through child processes.
+func configureCommand(cmd *exec.Cmd) {
+	cmd.WaitDelay = time.Second
+}
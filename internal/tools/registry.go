@@ -1,9 +1,11 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/openai/openai-go/v3"
 )
@@ -31,6 +33,14 @@ func (r *Registry) Register(tool ToolDefinition) error {
 	return nil
 }
 
+// Replace registers tool, replacing a tool of the same name if there is one.
+func (r *Registry) Replace(tool ToolDefinition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tools[tool.Name] = tool
+}
+
 func (r *Registry) Get(name string) (ToolDefinition, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -73,11 +83,38 @@ type ToolResult struct {
 	CallID string
 }
 
-func (r *Registry) ExecuteTool(name string, arguments json.RawMessage, log interface{ Debug(format string, args ...interface{}); Error(format string, args ...interface{}); Warn(format string, args ...interface{}) }) (string, error) {
+// TimeoutError reports a tool call that ran past its time limit. Any process
+// the tool started has been killed.
+type TimeoutError struct {
+	Tool    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("tool '%s' timed out after %s", e.Tool, e.Timeout)
+}
+
+// ToolMessage is the structured result reported to the model in place of the
+// tool's output.
+func (e *TimeoutError) ToolMessage() string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"error":           "timeout",
+		"tool":            e.Tool,
+		"timeout_seconds": e.Timeout.Seconds(),
+		"message":         e.Error() + " and was stopped; try a narrower or faster operation",
+	})
+	return string(data)
+}
+
+func (r *Registry) ExecuteTool(ctx context.Context, env *Env, name string, arguments json.RawMessage) (string, error) {
 	tool, exists := r.Get(name)
 	if !exists {
 		return "", fmt.Errorf("tool '%s' not found", name)
 	}
 
-	return tool.Function(arguments, log)
+	fn := tool.Func()
+	if fn == nil {
+		return "", fmt.Errorf("tool '%s' has no implementation", name)
+	}
+	return fn(ctx, env, arguments)
 }
\ No newline at end of file
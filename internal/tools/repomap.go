@@ -0,0 +1,257 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RepoMapHeader starts every repository map, so it can be told apart from
+// other system messages.
+const RepoMapHeader = "# Repository map"
+
+// maxRepoMapDirFiles is the number of files listed per directory.
+const maxRepoMapDirFiles = 40
+
+// repoMapCache is the last map rendered for a workspace. It is reused while
+// the listed files keep their names, sizes and modification times.
+type repoMapCache struct {
+	fingerprint uint64
+	budget      int
+	text        string
+}
+
+var repoMaps = struct {
+	sync.Mutex
+	byRoot map[string]repoMapCache
+}{byRoot: map[string]repoMapCache{}}
+
+// RepoMap describes the workspace for the system prompt: the files of each
+// directory and the exported symbols of each Go package. It is cut to about
+// budget tokens as measured by countTokens, giving the file listing at most
+// half of it when there are Go packages. Unless a listed file changed, the
+// previous map is returned without indexing and rendering it again.
+func RepoMap(ctx context.Context, workspace *Workspace, budget int, countTokens func(string) int) (string, error) {
+	dirLines, fingerprint, err := repoMapDirs(ctx, workspace)
+	if err != nil {
+		return "", err
+	}
+
+	root := workspace.Root()
+	repoMaps.Lock()
+	cached, ok := repoMaps.byRoot[root]
+	repoMaps.Unlock()
+	if ok && cached.fingerprint == fingerprint && cached.budget == budget {
+		return cached.text, nil
+	}
+
+	files, err := goIndexFor(root).refresh(ctx, workspace, noopLogger{})
+	if err != nil {
+		return "", err
+	}
+	packages := repoMapPackages(files)
+
+	header := RepoMapHeader + "\n" +
+		"Generated from the workspace at the start of the turn; ignored and hidden files are left out. " +
+		"Use the tools to read files before relying on details.\n"
+	used := countTokens(header)
+	var b strings.Builder
+	b.WriteString(header)
+
+	// add writes a section with as many of its lines as fit in limit
+	add := func(title string, lines []string, limit int) {
+		if len(lines) == 0 {
+			return
+		}
+		title = "\n## " + title + "\n"
+		used += countTokens(title)
+		b.WriteString(title)
+
+		written := 0
+		for _, line := range lines {
+			cost := countTokens(line) + 1
+			if used+cost > limit {
+				break
+			}
+			b.WriteString(line)
+			b.WriteString("\n")
+			used += cost
+			written++
+		}
+		if written < len(lines) {
+			fmt.Fprintf(&b, "... (%d more not shown)\n", len(lines)-written)
+		}
+	}
+
+	filesLimit := budget
+	if len(packages) > 0 {
+		filesLimit = budget / 2
+	}
+	add("Files", dirLines, filesLimit)
+	add("Go packages (exported symbols)", packages, budget)
+
+	repoMaps.Lock()
+	repoMaps.byRoot[root] = repoMapCache{fingerprint: fingerprint, budget: budget, text: b.String()}
+	repoMaps.Unlock()
+	return b.String(), nil
+}
+
+// repoMapDirs lists the files of each directory, one line per directory. It
+// also returns a hash of the path, size and modification time of every file
+// listed, which changes whenever the map may change.
+func repoMapDirs(ctx context.Context, workspace *Workspace) ([]string, uint64, error) {
+	root := workspace.Root()
+	ignore, _ := workspaceIgnoreMatcher(workspace, root)
+	dirs := map[string][]string{}
+	hash := fnv.New64a()
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if strings.HasPrefix(d.Name(), ".") || d.IsDir() && goSkipDirs[d.Name()] || ignore.ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			ignore.loadDir(rel)
+			return nil
+		}
+		dir := path.Dir(rel)
+		dirs[dir] = append(dirs[dir], d.Name())
+		if info, err := d.Info(); err == nil {
+			fmt.Fprintf(hash, "%s\x00%d\x00%d\n", rel, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, dir := range names {
+		files := dirs[dir]
+		label := dir + "/"
+		if dir == "." {
+			label = "./"
+		}
+		line := label + ": " + strings.Join(files[:min(len(files), maxRepoMapDirFiles)], " ")
+		if len(files) > maxRepoMapDirFiles {
+			line += fmt.Sprintf(" ... (+%d more)", len(files)-maxRepoMapDirFiles)
+		}
+		lines = append(lines, line)
+	}
+	return lines, hash.Sum64(), nil
+}
+
+// repoMapPackages summarizes the exported declarations of each Go package,
+// leaving out test files.
+func repoMapPackages(files map[string]*goFile) []string {
+	type pkgSymbols struct {
+		name    string
+		types   []string
+		methods map[string][]string
+		funcs   []string
+		values  []string
+	}
+	packages := map[string]*pkgSymbols{}
+
+	for rel, file := range files {
+		if strings.HasSuffix(rel, "_test.go") {
+			continue
+		}
+		dir := path.Dir(rel)
+		for _, d := range file.decls {
+			pkg, ok := packages[dir]
+			if !ok {
+				pkg = &pkgSymbols{name: d.pkg, methods: map[string][]string{}}
+				packages[dir] = pkg
+			}
+			if !ast.IsExported(d.name) {
+				continue
+			}
+
+			switch d.kind {
+			case "type":
+				pkg.types = append(pkg.types, d.name)
+			case "interface":
+				pkg.types = append(pkg.types, d.name)
+				for _, m := range d.methods {
+					if ast.IsExported(m.name) {
+						pkg.methods[d.name] = append(pkg.methods[d.name], m.name)
+					}
+				}
+			case "method":
+				pkg.methods[d.recv] = append(pkg.methods[d.recv], d.name)
+			case "func":
+				pkg.funcs = append(pkg.funcs, d.name)
+			default:
+				pkg.values = append(pkg.values, d.name)
+			}
+		}
+	}
+
+	dirs := make([]string, 0, len(packages))
+	for dir := range packages {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var blocks []string
+	for _, dir := range dirs {
+		pkg := packages[dir]
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s (package %s)", dir, pkg.name)
+
+		if len(pkg.types) > 0 {
+			sort.Strings(pkg.types)
+			types := make([]string, 0, len(pkg.types))
+			for _, name := range pkg.types {
+				if methods := pkg.methods[name]; len(methods) > 0 {
+					sort.Strings(methods)
+					name += "{" + strings.Join(methods, ", ") + "}"
+				}
+				types = append(types, name)
+			}
+			b.WriteString("\n  types: " + strings.Join(types, ", "))
+		}
+		if len(pkg.funcs) > 0 {
+			sort.Strings(pkg.funcs)
+			b.WriteString("\n  funcs: " + strings.Join(pkg.funcs, ", "))
+		}
+		if len(pkg.values) > 0 {
+			sort.Strings(pkg.values)
+			b.WriteString("\n  vars and consts: " + strings.Join(pkg.values, ", "))
+		}
+		blocks = append(blocks, b.String())
+	}
+	return blocks
+}
@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepoMapIsCachedUntilFilesChange(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":  "module example\n",
+		"main.go": "package example\n\nfunc Old() {}\n",
+	})
+	workspace := NewWorkspace(root)
+
+	counted := 0
+	countTokens := func(s string) int {
+		counted++
+		return len(s) / 4
+	}
+	build := func() string {
+		t.Helper()
+		repoMap, err := RepoMap(context.Background(), workspace, 1000, countTokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repoMap
+	}
+
+	first := build()
+	if !strings.Contains(first, "Old") {
+		t.Fatalf("map is missing Old:\n%s", first)
+	}
+
+	counted = 0
+	if again := build(); again != first || counted != 0 {
+		t.Errorf("unchanged workspace: map rendered again (%d token counts)", counted)
+	}
+
+	writeFiles(t, root, map[string]string{"main.go": "package example\n\nfunc Renamed() {}\n"})
+	if updated := build(); !strings.Contains(updated, "Renamed") {
+		t.Errorf("edited file is not reflected:\n%s", updated)
+	}
+
+	if err := os.Remove(filepath.Join(root, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	if updated := build(); strings.Contains(updated, "main.go") {
+		t.Errorf("removed file is still listed:\n%s", updated)
+	}
+}
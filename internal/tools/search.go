@@ -0,0 +1,425 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// searchFileTypes maps common ripgrep file types to the extensions the
+// built-in search matches for them. Other types are rejected, as rg does
+// for types it does not know.
+var searchFileTypes = map[string][]string{
+	"c":        {".c", ".h"},
+	"cpp":      {".cpp", ".cc", ".cxx", ".c++", ".hpp", ".hh", ".hxx", ".h", ".inl"},
+	"cs":       {".cs"},
+	"css":      {".css", ".scss"},
+	"go":       {".go"},
+	"html":     {".html", ".htm"},
+	"java":     {".java"},
+	"js":       {".js", ".jsx", ".mjs", ".cjs", ".vue"},
+	"json":     {".json"},
+	"kotlin":   {".kt", ".kts"},
+	"lua":      {".lua"},
+	"markdown": {".md", ".markdown", ".mdx"},
+	"md":       {".md", ".markdown", ".mdx"},
+	"php":      {".php"},
+	"protobuf": {".proto"},
+	"py":       {".py", ".pyi"},
+	"rb":       {".rb"},
+	"ruby":     {".rb"},
+	"rust":     {".rs"},
+	"sh":       {".sh", ".bash", ".zsh"},
+	"sql":      {".sql"},
+	"swift":    {".swift"},
+	"toml":     {".toml"},
+	"ts":       {".ts", ".tsx", ".cts", ".mts"},
+	"txt":      {".txt"},
+	"xml":      {".xml", ".xsd", ".xsl", ".svg"},
+	"yaml":     {".yaml", ".yml"},
+}
+
+// lineSpan is the first and last line of a match.
+type lineSpan struct {
+	start, end int
+}
+
+// rgMessage is a line of rg --json output.
+type rgMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		Path       rgText `json:"path"`
+		Lines      rgText `json:"lines"`
+		LineNumber int    `json:"line_number"`
+	} `json:"data"`
+}
+
+// rgText holds text that rg reports as base64 bytes if it is not UTF-8.
+type rgText struct {
+	Text  string `json:"text"`
+	Bytes string `json:"bytes"`
+}
+
+func (t rgText) String() string {
+	if t.Bytes != "" {
+		if data, err := base64.StdEncoding.DecodeString(t.Bytes); err == nil {
+			return string(data)
+		}
+	}
+	return t.Text
+}
+
+// ripgrep runs rg in the workspace root. Results are sorted by path so pages
+// of them are stable across calls.
+func ripgrep(ctx context.Context, env *Env, input CodeSearchInput, target string) ([]SearchMatch, error) {
+	args := []string{"--color=never", "--sort=path"}
+
+	// Add case sensitivity flag
+	if !input.CaseSensitive {
+		args = append(args, "--ignore-case")
+	}
+	if input.FixedStrings {
+		args = append(args, "--fixed-strings")
+	}
+	if input.Multiline {
+		args = append(args, "--multiline")
+	}
+
+	// Add file type filter if specified
+	if input.FileType != "" {
+		args = append(args, "--type", input.FileType)
+	}
+	for _, glob := range input.Include {
+		args = append(args, "--glob", glob)
+	}
+	for _, glob := range input.Exclude {
+		args = append(args, "--glob", "!"+glob)
+	}
+
+	if input.FilesWithMatches {
+		args = append(args, "--files-with-matches")
+	} else {
+		args = append(args, "--json",
+			"--before-context", strconv.Itoa(input.ContextBefore),
+			"--after-context", strconv.Itoa(input.ContextAfter))
+	}
+	args = append(args, "--regexp", input.Pattern, "--", target)
+
+	env.Logger.Debug("Executing ripgrep with args: %v", args)
+
+	cmd := exec.CommandContext(ctx, "rg", args...)
+	cmd.Dir = env.WorkspaceRoot
+	configureCommand(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+
+	// ripgrep returns exit code 1 when no matches are found, which is not an error
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == 1 {
+			return nil, nil
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	if input.FilesWithMatches {
+		var matches []SearchMatch
+		for _, name := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			if name != "" {
+				matches = append(matches, SearchMatch{Path: searchPath(name)})
+			}
+		}
+		return matches, nil
+	}
+	return parseRipgrepJSON(output, input.ContextBefore, input.ContextAfter)
+}
+
+// parseRipgrepJSON collects the match and context lines rg reports for each
+// file and attaches the context to the matches.
+func parseRipgrepJSON(output []byte, before, after int) ([]SearchMatch, error) {
+	var matches []SearchMatch
+	var name string
+	var spans []lineSpan
+	lines := map[int]string{}
+
+	flush := func() {
+		lineAt := func(n int) (string, bool) {
+			line, ok := lines[n]
+			return line, ok
+		}
+		matches = append(matches, contextMatches(name, spans, before, after, lineAt)...)
+		spans = nil
+		lines = map[int]string{}
+	}
+
+	for _, raw := range bytes.Split(output, []byte("\n")) {
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		var msg rgMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return nil, fmt.Errorf("unexpected ripgrep output: %w", err)
+		}
+
+		switch msg.Type {
+		case "begin":
+			name = searchPath(msg.Data.Path.String())
+		case "match", "context":
+			text := strings.TrimSuffix(msg.Data.Lines.String(), "\n")
+			n := msg.Data.LineNumber
+			for i, line := range strings.Split(text, "\n") {
+				lines[n+i] = strings.TrimSuffix(line, "\r")
+			}
+			if msg.Type == "match" {
+				spans = append(spans, lineSpan{start: n, end: n + strings.Count(text, "\n")})
+			}
+		case "end":
+			flush()
+		}
+	}
+	return matches, nil
+}
+
+// contextMatches builds results for the match spans of a file, taking their
+// text and context from lineAt.
+func contextMatches(name string, spans []lineSpan, before, after int, lineAt func(n int) (string, bool)) []SearchMatch {
+	var matches []SearchMatch
+	for _, span := range spans {
+		match := SearchMatch{Path: name, Line: span.start}
+
+		var text []string
+		for n := span.start; n <= span.end; n++ {
+			line, _ := lineAt(n)
+			text = append(text, searchLine(line))
+		}
+		match.Text = strings.Join(text, "\n")
+
+		for n := max(span.start-before, 1); n < span.start; n++ {
+			if line, ok := lineAt(n); ok {
+				match.Before = append(match.Before, searchLine(line))
+			}
+		}
+		for n := span.end + 1; n <= span.end+after; n++ {
+			line, ok := lineAt(n)
+			if !ok {
+				break
+			}
+			match.After = append(match.After, searchLine(line))
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// searchPath reports paths relative to the workspace root without rg's "./".
+func searchPath(name string) string {
+	return strings.TrimPrefix(filepath.ToSlash(name), "./")
+}
+
+// searchLine shortens very long lines, such as those of minified files.
+func searchLine(line string) string {
+	if utf8.RuneCountInString(line) > maxLineRunes {
+		return string([]rune(line)[:maxLineRunes]) + "… (line truncated)"
+	}
+	return line
+}
+
+// searchFiles is the built-in replacement for ripgrep. It skips the files rg
+// skips by default (ignored, hidden and binary ones) and returns the same
+// results in the same order.
+func searchFiles(ctx context.Context, env *Env, input CodeSearchInput, target string) ([]SearchMatch, error) {
+	pattern := input.Pattern
+	if input.FixedStrings {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	flags := "m"
+	if !input.CaseSensitive {
+		flags += "i"
+	}
+	re := regexp.MustCompile("(?" + flags + ")" + pattern)
+
+	var extensions []string
+	if input.FileType != "" {
+		extensions = searchFileTypes[input.FileType]
+		if extensions == nil {
+			return nil, fmt.Errorf("unrecognized file type: %s", input.FileType)
+		}
+	}
+
+	var include, exclude []*globMatcher
+	for _, glob := range input.Include {
+		matcher, err := newGlobMatcher(glob)
+		if err != nil {
+			return nil, err
+		}
+		include = append(include, matcher)
+	}
+	for _, glob := range input.Exclude {
+		matcher, err := newGlobMatcher(glob)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, matcher)
+	}
+
+	root := target
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(env.WorkspaceRoot, filepath.FromSlash(target))
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return searchFile(root, searchPath(target), re, input)
+	}
+
+	ignore, prefix := workspaceIgnoreMatcher(env.Workspace, root)
+
+	var matches []SearchMatch
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			env.Logger.Warn("Skipping %s: %v", p, err)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if strings.HasPrefix(d.Name(), ".") || ignore.ignored(path.Join(prefix, rel), d.IsDir()) || matchesAny(exclude, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			ignore.loadDir(path.Join(prefix, rel))
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if extensions != nil && !hasExtension(d.Name(), extensions) {
+			return nil
+		}
+		if include != nil && !matchesAny(include, rel) {
+			return nil
+		}
+
+		found, err := searchFile(p, searchPath(path.Join(target, rel)), re, input)
+		if err != nil {
+			env.Logger.Warn("Skipping %s: %v", p, err)
+			return nil
+		}
+		matches = append(matches, found...)
+		return nil
+	})
+	return matches, err
+}
+
+// searchFile returns the matches in a text file. In files_with_matches mode
+// a file yields at most one result.
+func searchFile(p, name string, re *regexp.Regexp, input CodeSearchInput) ([]SearchMatch, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(data[:min(len(data), binarySniffBytes)], 0) >= 0 {
+		return nil, nil
+	}
+
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	lines := strings.Split(text, "\n")
+
+	var spans []lineSpan
+	if input.Multiline {
+		// Offsets at which each line starts, to map matches to lines
+		starts := make([]int, len(lines))
+		for i, offset := 1, 0; i < len(lines); i++ {
+			offset += len(lines[i-1]) + 1
+			starts[i] = offset
+		}
+		lineOf := func(offset int) int {
+			return sort.Search(len(starts), func(i int) bool { return starts[i] > offset })
+		}
+
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			start, end := lineOf(loc[0]), lineOf(max(loc[1]-1, loc[0]))
+			if n := len(spans); n > 0 && start <= spans[n-1].end {
+				spans[n-1].end = max(spans[n-1].end, end)
+				continue
+			}
+			spans = append(spans, lineSpan{start: start, end: end})
+		}
+	} else {
+		for i, line := range lines {
+			if re.MatchString(line) {
+				spans = append(spans, lineSpan{start: i + 1, end: i + 1})
+			}
+		}
+	}
+
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	if input.FilesWithMatches {
+		return []SearchMatch{{Path: name}}, nil
+	}
+
+	lineAt := func(n int) (string, bool) {
+		if n < 1 || n > len(lines) {
+			return "", false
+		}
+		return strings.TrimSuffix(lines[n-1], "\r"), true
+	}
+	return contextMatches(name, spans, input.ContextBefore, input.ContextAfter, lineAt), nil
+}
+
+func matchesAny(globs []*globMatcher, relPath string) bool {
+	for _, glob := range globs {
+		if glob.Match(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtension(name string, extensions []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
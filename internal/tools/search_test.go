@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(format string, args ...interface{}) {}
+func (nopLogger) Error(format string, args ...interface{}) {}
+func (nopLogger) Warn(format string, args ...interface{})  {}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func searchedPaths(t *testing.T, root string, input CodeSearchInput) []string {
+	t.Helper()
+	env := &Env{WorkspaceRoot: root, Workspace: NewWorkspace(root), Logger: nopLogger{}}
+	matches, err := searchFiles(context.Background(), env, input, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, match := range matches {
+		paths = append(paths, match.Path)
+	}
+	return paths
+}
+
+func TestSearchFilesGitignoreNeedsRepository(t *testing.T) {
+	root := t.TempDir()
+	if len(newIgnoreMatcher(root).repos) > 0 {
+		t.Skip("the temporary directory is inside a git repository")
+	}
+	writeFiles(t, root, map[string]string{
+		".gitignore":     "build/\n",
+		".ignore":        "*.log\n",
+		"main.go":        "needle\n",
+		"build/out.go":   "needle\n",
+		"debug.log":      "needle\n",
+		"sub/.gitignore": "*.go\n",
+		"sub/a.go":       "needle\n",
+	})
+	input := CodeSearchInput{Pattern: "needle", FilesWithMatches: true}
+
+	// Outside a repository only .ignore applies
+	got := strings.Join(searchedPaths(t, root, input), " ")
+	if want := "build/out.go main.go sub/a.go"; got != want {
+		t.Errorf("without .git: got %q, want %q", got, want)
+	}
+
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	got = strings.Join(searchedPaths(t, root, input), " ")
+	if want := "main.go"; got != want {
+		t.Errorf("with .git: got %q, want %q", got, want)
+	}
+
+	// A repository above the searched directory counts too
+	sub := filepath.Join(root, "sub")
+	if got := searchedPaths(t, sub, input); len(got) != 0 {
+		t.Errorf("below .git: got %q, want no matches", got)
+	}
+}
+
+func TestSearchFilesRejectsUnknownFileType(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"main.go": "needle\n"})
+	env := &Env{WorkspaceRoot: root, Workspace: NewWorkspace(root), Logger: nopLogger{}}
+
+	_, err := searchFiles(context.Background(), env, CodeSearchInput{Pattern: "needle", FileType: "golang"}, ".")
+	if err == nil || err.Error() != "unrecognized file type: golang" {
+		t.Errorf("err = %v, want unrecognized file type", err)
+	}
+	if got := searchedPaths(t, root, CodeSearchInput{Pattern: "needle", FileType: "go"}); len(got) != 1 {
+		t.Errorf("file_type go: got %q", got)
+	}
+}
+
+func TestCodeSearchPagination(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{}
+	for _, name := range []string{"a.go", "b.go", "sub/c.go"} {
+		var b strings.Builder
+		for i := 1; i <= 4; i++ {
+			fmt.Fprintf(&b, "before %d\nneedle %d\nafter %d\n", i, i, i)
+		}
+		files[name] = b.String()
+	}
+	writeFiles(t, root, files)
+	env := &Env{WorkspaceRoot: root, Workspace: NewWorkspace(root), Logger: nopLogger{}}
+
+	search := func(input CodeSearchInput) CodeSearchResult {
+		t.Helper()
+		data, _ := json.Marshal(input)
+		out, err := CodeSearch(context.Background(), env, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result CodeSearchResult
+		if err := json.Unmarshal([]byte(out), &result); err != nil {
+			t.Fatalf("invalid result %s: %v", out, err)
+		}
+		return result
+	}
+	key := func(match SearchMatch) string {
+		return fmt.Sprintf("%s:%d", match.Path, match.Line)
+	}
+
+	var all []string
+	for _, match := range search(CodeSearchInput{Pattern: "needle", Limit: maxSearchLimit}).Matches {
+		all = append(all, key(match))
+	}
+	if len(all) != 12 {
+		t.Fatalf("got %d matches, want 12", len(all))
+	}
+
+	var paged []string
+	offsets := []int{0}
+	input := CodeSearchInput{Pattern: "needle", Limit: 5, ContextBefore: 1, ContextAfter: 1}
+	for {
+		result := search(input)
+		if result.Total != 12 || result.Offset != input.Offset {
+			t.Fatalf("page at %d: total %d, offset %d", input.Offset, result.Total, result.Offset)
+		}
+		for _, match := range result.Matches {
+			paged = append(paged, key(match))
+
+			n := strings.TrimPrefix(match.Text, "needle ")
+			if len(match.Before) != 1 || match.Before[0] != "before "+n || len(match.After) != 1 || match.After[0] != "after "+n {
+				t.Errorf("%s: context %q %q", key(match), match.Before, match.After)
+			}
+		}
+		if result.NextOffset == 0 {
+			break
+		}
+		input.Offset = result.NextOffset
+		offsets = append(offsets, input.Offset)
+	}
+
+	if fmt.Sprint(offsets) != "[0 5 10]" {
+		t.Errorf("pages started at %v, want [0 5 10]", offsets)
+	}
+	if strings.Join(paged, " ") != strings.Join(all, " ") {
+		t.Errorf("pages returned\n%v\nwant every match once, in order\n%v", paged, all)
+	}
+}
@@ -1,13 +1,20 @@
 package tools
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/invopop/jsonschema"
 	"github.com/openai/openai-go/v3"
@@ -17,7 +24,24 @@ type ToolDefinition struct {
 	Name        string                    `json:"name"`
 	Description string                    `json:"description"`
 	InputSchema openai.FunctionParameters `json:"input_schema"`
-	Function    func(input json.RawMessage, log interface{ Debug(format string, args ...interface{}); Error(format string, args ...interface{}); Warn(format string, args ...interface{}) }) (string, error)
+	// Handler runs the tool. Tools still written against the original
+	// Function contract are adapted with LegacyFunc when Handler is unset.
+	Handler  ToolFunc
+	Function func(input json.RawMessage, log Logger) (string, error)
+	// Timeout is the tool's default time limit; zero uses the executor default.
+	Timeout time.Duration
+	// ReadOnly tools never change files or run commands, so they can be
+	// allowed without asking the user.
+	ReadOnly bool
+	// Targets reports what a call acts on, for permission rules.
+	Targets func(input json.RawMessage) Targets
+}
+
+// Targets are the command and file paths a tool call acts on. Paths are as
+// given in the input, i.e. relative to the workspace root or absolute.
+type Targets struct {
+	Command string
+	Paths   []string
 }
 
 func (t ToolDefinition) FunctionDefinition() openai.FunctionDefinitionParam {
@@ -31,34 +55,105 @@ func (t ToolDefinition) FunctionDefinition() openai.FunctionDefinitionParam {
 	return def
 }
 
+// Func returns the function that executes the tool.
+func (t ToolDefinition) Func() ToolFunc {
+	if t.Handler != nil {
+		return t.Handler
+	}
+	if t.Function != nil {
+		return LegacyFunc(t.Function)
+	}
+	return nil
+}
+
 func (t ToolDefinition) ToolConfig() openai.ChatCompletionToolUnionParam {
 	return openai.ChatCompletionFunctionTool(t.FunctionDefinition())
 }
 
 // Input structs
 type ReadFileInput struct {
-	Path string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
+	Path   string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
+	Offset int    `json:"offset,omitempty" jsonschema_description:"Optional line number to start reading from (1-based). Defaults to 1."`
+	Limit  int    `json:"limit,omitempty" jsonschema_description:"Optional maximum number of lines to read. Defaults to 2000."`
 }
 
 type ListFilesInput struct {
-	Path string `json:"path,omitempty" jsonschema_description:"Optional relative path to list files from. Defaults to current directory if not provided."`
+	Path           string `json:"path,omitempty" jsonschema_description:"Optional relative path to list files from. Defaults to current directory if not provided."`
+	MaxDepth       int    `json:"max_depth,omitempty" jsonschema_description:"Optional maximum depth to descend; 1 lists only the direct children. Defaults to unlimited."`
+	Pattern        string `json:"pattern,omitempty" jsonschema_description:"Optional glob files must match, e.g. '*.go' or 'internal/**/*_test.go' (relative to path). Directories are not listed when set."`
+	Limit          int    `json:"limit,omitempty" jsonschema_description:"Optional maximum number of entries to return. Defaults to 500."`
+	Tree           bool   `json:"tree,omitempty" jsonschema_description:"Render a compact tree with file sizes instead of a JSON list."`
+	IncludeIgnored bool   `json:"include_ignored,omitempty" jsonschema_description:"Also list files excluded by .gitignore and .ignore files. The .git directory is never listed."`
 }
 
 type BashInput struct {
-	Command string `json:"command" jsonschema_description:"The bash command to execute."`
+	Command    string            `json:"command" jsonschema_description:"The bash command to execute."`
+	WorkingDir string            `json:"working_dir,omitempty" jsonschema_description:"Optional directory to run the command in, relative to the workspace root. Defaults to the workspace root."`
+	Env        map[string]string `json:"env,omitempty" jsonschema_description:"Optional environment variables to set for the command."`
+}
+
+// BashResult is returned to the model as JSON.
+type BashResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
 }
 
 type EditFileInput struct {
 	Path   string `json:"path" jsonschema_description:"The path to the file"`
-	OldStr string `json:"old_str" jsonschema_description:"Text to search for - must match exactly and must only have one match exactly"`
+	OldStr string `json:"old_str,omitempty" jsonschema_description:"Text to search for - must match exactly and must only have one match exactly"`
+	NewStr string `json:"new_str,omitempty" jsonschema_description:"Text to replace old_str with"`
+	Edits  []Edit `json:"edits,omitempty" jsonschema_description:"Optional list of replacements applied in order after old_str/new_str. Either all of them are applied or none."`
+}
+
+type Edit struct {
+	OldStr string `json:"old_str" jsonschema_description:"Text to search for - must match exactly once in the file as edited so far"`
 	NewStr string `json:"new_str" jsonschema_description:"Text to replace old_str with"`
 }
 
+type ApplyPatchInput struct {
+	Patch string `json:"patch" jsonschema_description:"Unified diff to apply, e.g. the output of git diff. Paths are relative to the workspace root; a/ and b/ prefixes are stripped."`
+}
+
+type GoSymbolsInput struct {
+	Query string `json:"query" jsonschema:"enum=definition,enum=methods,enum=callers,enum=implementations" jsonschema_description:"definition: where a symbol is declared; methods: methods of a type; callers: calls of a function or method; implementations: types implementing an interface"`
+	Name  string `json:"name" jsonschema_description:"Symbol name, e.g. Agent, runInference, Agent.Run for a method or agent.NewAgent for a package member"`
+}
+
 type CodeSearchInput struct {
-	Pattern       string `json:"pattern" jsonschema_description:"The search pattern or regex to look for"`
-	Path          string `json:"path,omitempty" jsonschema_description:"Optional path to search in (file or directory)"`
-	FileType      string `json:"file_type,omitempty" jsonschema_description:"Optional file extension to limit search to (e.g., 'go', 'js', 'py')"`
-	CaseSensitive bool   `json:"case_sensitive,omitempty" jsonschema_description:"Whether the search should be case sensitive (default: false)"`
+	Pattern          string   `json:"pattern" jsonschema_description:"The search pattern or regex to look for"`
+	Path             string   `json:"path,omitempty" jsonschema_description:"Optional path to search in (file or directory)"`
+	FileType         string   `json:"file_type,omitempty" jsonschema_description:"Optional ripgrep file type to limit search to (e.g., 'go', 'js', 'py')"`
+	CaseSensitive    bool     `json:"case_sensitive,omitempty" jsonschema_description:"Whether the search should be case sensitive (default: false)"`
+	FixedStrings     bool     `json:"fixed_strings,omitempty" jsonschema_description:"Treat the pattern as a literal string instead of a regex"`
+	Multiline        bool     `json:"multiline,omitempty" jsonschema_description:"Allow the pattern to match across lines, using \\n for line breaks"`
+	Include          []string `json:"include,omitempty" jsonschema_description:"Only search files matching these globs (e.g., '*.go', 'internal/**')"`
+	Exclude          []string `json:"exclude,omitempty" jsonschema_description:"Skip files and directories matching these globs"`
+	ContextBefore    int      `json:"context_before,omitempty" jsonschema_description:"Lines of context to include before each match (max 10)"`
+	ContextAfter     int      `json:"context_after,omitempty" jsonschema_description:"Lines of context to include after each match (max 10)"`
+	FilesWithMatches bool     `json:"files_with_matches,omitempty" jsonschema_description:"Only return the paths of files containing a match"`
+	Offset           int      `json:"offset,omitempty" jsonschema_description:"Number of results to skip; pass next_offset from the previous result to see more"`
+	Limit            int      `json:"limit,omitempty" jsonschema_description:"Maximum number of results to return (default 50, max 500)"`
+}
+
+// CodeSearchResult is returned to the model as JSON. Files is set instead of
+// Matches in files_with_matches mode.
+type CodeSearchResult struct {
+	Matches    []SearchMatch `json:"matches,omitempty"`
+	Files      []string      `json:"files,omitempty"`
+	Total      int           `json:"total"`
+	Offset     int           `json:"offset"`
+	NextOffset int           `json:"next_offset,omitempty"`
+}
+
+// SearchMatch is a match with its context. Line is the first line of the
+// match; a multiline match has several lines in Text.
+type SearchMatch struct {
+	Path   string   `json:"path"`
+	Line   int      `json:"line,omitempty"`
+	Text   string   `json:"text,omitempty"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
 }
 
 // Schemas
@@ -66,101 +161,337 @@ var ReadFileInputSchema = GenerateSchema[ReadFileInput]()
 var ListFilesInputSchema = GenerateSchema[ListFilesInput]()
 var BashInputSchema = GenerateSchema[BashInput]()
 var EditFileInputSchema = GenerateSchema[EditFileInput]()
+var ApplyPatchInputSchema = GenerateSchema[ApplyPatchInput]()
+var GoSymbolsInputSchema = GenerateSchema[GoSymbolsInput]()
 var CodeSearchInputSchema = GenerateSchema[CodeSearchInput]()
 
 // Tool definitions
 var ReadFileDefinition = ToolDefinition{
 	Name:        "read_file",
-	Description: "Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names.",
+	Description: `Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names.
+	Lines are prefixed with their line number. Use offset and limit to read part of a large file.`,
 	InputSchema: ReadFileInputSchema,
-	Function:    ReadFile,
+	Handler:     ReadFile,
+	ReadOnly:    true,
 }
 
 var ListFilesDefinition = ToolDefinition{
 	Name:        "list_files",
-	Description: "List files and directories at a given path. If no path is provided, lists files in the current directory.",
+	Description: `List files and directories at a given path. If no path is provided, lists files in the current directory.
+	Files excluded by .gitignore and .ignore are skipped. Use max_depth, pattern and limit to keep large listings small.`,
 	InputSchema: ListFilesInputSchema,
-	Function:    ListFiles,
+	Handler:     ListFiles,
+	ReadOnly:    true,
 }
 
 var BashDefinition = ToolDefinition{
-	Name:        "bash",
-	Description: "Execute a bash command and return its output. Use this to run shell commands.",
+	Name: "bash",
+	Description: `Execute a bash command and return its output. Use this to run shell commands.
+	The result is JSON with the exit_code, stdout and stderr of the command.`,
 	InputSchema: BashInputSchema,
-	Function:    Bash,
+	Handler:     Bash,
+	Timeout:     120 * time.Second,
+	Targets: func(input json.RawMessage) Targets {
+		var bashInput BashInput
+		_ = json.Unmarshal(input, &bashInput)
+		return Targets{Command: bashInput.Command}
+	},
+}
+
+// DefaultShell runs bash tool commands unless configured otherwise.
+const DefaultShell = "bash"
+
+// Shells maps the supported shells to the arguments that run a command string.
+var Shells = map[string][]string{
+	"bash": {"bash", "-c"},
+	"sh":   {"sh", "-c"},
+	"zsh":  {"zsh", "-c"},
+	"nu":   {"nu", "-c"},
+}
+
+// maxShellOutputBytes bounds each of stdout and stderr returned to the model.
+const maxShellOutputBytes = 32 * 1024
+
+// NewBashDefinition returns the bash tool running commands in the given shell.
+func NewBashDefinition(shell string) (ToolDefinition, error) {
+	if shell == "" {
+		shell = DefaultShell
+	}
+	args, ok := Shells[shell]
+	if !ok {
+		return ToolDefinition{}, fmt.Errorf("unsupported shell %q, expected one of bash, sh, zsh, nu", shell)
+	}
+
+	def := BashDefinition
+	def.Handler = func(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+		return runShell(ctx, env, input, args)
+	}
+	if shell != DefaultShell {
+		def.Description = strings.Replace(def.Description, "Execute a bash command", "Execute a "+shell+" command", 1)
+	}
+	return def, nil
 }
 
 var EditFileDefinition = ToolDefinition{
 	Name: "edit_file",
 	Description: `Make edits to a text file.
 	Replace 'old_str' with 'new_str' in the given file. 'old_str' and 'new_str' MUST be different from each other.
+	Use 'edits' to make several replacements in one call; if any of them does not match, the file is left unchanged.
 	If the file specified with path doesn't exist, it will be created.
+	Returns a unified diff of the changes.
 	`,
 	InputSchema: EditFileInputSchema,
-	Function:    EditFile,
+	Handler:     EditFile,
+	Targets: func(input json.RawMessage) Targets {
+		var editFileInput EditFileInput
+		_ = json.Unmarshal(input, &editFileInput)
+		return Targets{Paths: []string{editFileInput.Path}}
+	},
+}
+
+var ApplyPatchDefinition = ToolDefinition{
+	Name: "apply_patch",
+	Description: `Apply a unified diff that may span several files.
+	Files can be created (--- /dev/null), deleted (+++ /dev/null), modified and renamed (git rename from/rename to headers).
+	Hunks are located near their line numbers and tolerate small offsets and whitespace differences.
+	If any hunk does not apply, no file is changed. Reports the result of every hunk.
+	`,
+	InputSchema: ApplyPatchInputSchema,
+	Handler:     ApplyPatch,
+	Targets: func(input json.RawMessage) Targets {
+		var applyPatchInput ApplyPatchInput
+		_ = json.Unmarshal(input, &applyPatchInput)
+		return Targets{Paths: patchPaths(applyPatchInput.Patch)}
+	},
 }
 
 var CodeSearchDefinition = ToolDefinition{
 	Name: "code_search",
-	Description: `Search for code patterns using ripgrep (rg).
+	Description: `Search for code patterns using ripgrep (rg), or a built-in search with the same output when rg is not installed.
 	Use this to find code patterns, function definitions, variable usage, or any text in the codebase.
-	You can search by pattern, file type, or directory.`,
+	You can search by pattern, file type, directory or globs, include context lines, or list only the matching files.
+	Results are JSON and paginated: when next_offset is set, call again with that offset to see more.`,
 	InputSchema: CodeSearchInputSchema,
-	Function:    CodeSearch,
+	Handler:     CodeSearch,
+	Timeout:     30 * time.Second,
+	ReadOnly:    true,
+}
+
+var GoSymbolsDefinition = ToolDefinition{
+	Name: "go_symbols",
+	Description: `Navigate Go code by parsing the workspace instead of searching text.
+	Finds the definition of a type, function, method, variable or constant, the methods of a type, the callers of a function or method, and the types implementing an interface.
+	Returns file:line locations with signatures. Callers and implementations are matched by name and method arity, without type checking.`,
+	InputSchema: GoSymbolsInputSchema,
+	Handler:     GoSymbols,
+	Timeout:     30 * time.Second,
+	ReadOnly:    true,
 }
 
 // Tool implementations
-func ReadFile(input json.RawMessage, log interface{ Debug(format string, args ...interface{}); Error(format string, args ...interface{}); Warn(format string, args ...interface{}) }) (string, error) {
+// Limits of read_file output
+const (
+	defaultReadLimit = 2000
+	maxReadBytes     = 64 * 1024
+	maxLineRunes     = 2000
+	binarySniffBytes = 8000
+)
+
+func ReadFile(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+	log := env.Logger
 	readFileInput := ReadFileInput{}
 	err := json.Unmarshal(input, &readFileInput)
 	if err != nil {
 		return "", fmt.Errorf("invalid input: %w", err)
 	}
+	if readFileInput.Offset < 0 || readFileInput.Limit < 0 {
+		return "", fmt.Errorf("offset and limit must not be negative")
+	}
+
+	path, err := env.Workspace.ResolveRead(readFileInput.Path)
+	if err != nil {
+		log.Warn("Rejected read of %s: %v", readFileInput.Path, err)
+		return "", err
+	}
 
-	log.Debug("Reading file: %s", readFileInput.Path)
-	content, err := os.ReadFile(readFileInput.Path)
+	log.Debug("Reading file: %s", path)
+	file, err := os.Open(path)
 	if err != nil {
 		log.Error("Failed to read file %s: %v", readFileInput.Path, err)
 		return "", err
 	}
-	log.Debug("Successfully read file %s (%d bytes)", readFileInput.Path, len(content))
-	return string(content), nil
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	head, _ := reader.Peek(binarySniffBytes)
+	if bytes.IndexByte(head, 0) >= 0 {
+		info, _ := file.Stat()
+		log.Warn("Refused to read binary file %s", readFileInput.Path)
+		if info != nil {
+			return "", fmt.Errorf("%s is a binary file (%d bytes) and cannot be read as text", readFileInput.Path, info.Size())
+		}
+		return "", fmt.Errorf("%s is a binary file and cannot be read as text", readFileInput.Path)
+	}
+
+	start := readFileInput.Offset
+	if start == 0 {
+		start = 1
+	}
+	limit := readFileInput.Limit
+	if limit == 0 {
+		limit = defaultReadLimit
+	}
+
+	var out strings.Builder
+	lineNo, last := 0, 0
+	truncated := false
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			if err != io.EOF {
+				return "", err
+			}
+			break
+		}
+		lineNo++
+
+		if lineNo < start || truncated {
+			continue
+		}
+		if lineNo >= start+limit {
+			truncated = true
+			continue
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if utf8.RuneCountInString(line) > maxLineRunes {
+			line = string([]rune(line)[:maxLineRunes]) + "… (line truncated)"
+		}
+		entry := fmt.Sprintf("%6d\t%s\n", lineNo, line)
+		if out.Len()+len(entry) > maxReadBytes && out.Len() > 0 {
+			truncated = true
+			continue
+		}
+		out.WriteString(entry)
+		last = lineNo
+	}
+
+	if lineNo == 0 {
+		return "(empty file)", nil
+	}
+	if start > lineNo {
+		return "", fmt.Errorf("offset %d is past the end of the file, which has %d lines", start, lineNo)
+	}
+	if truncated {
+		fmt.Fprintf(&out, "... (showing lines %d-%d of %d; call read_file with offset=%d to read more)\n", start, last, lineNo, last+1)
+	}
+
+	log.Debug("Successfully read file %s (lines %d-%d of %d)", readFileInput.Path, start, last, lineNo)
+	return out.String(), nil
+}
+
+// Limits of list_files output
+const (
+	defaultListLimit = 500
+	maxListLimit     = 5000
+)
+
+type listEntry struct {
+	path string
+	dir  bool
+	size int64
 }
 
-func ListFiles(input json.RawMessage, log interface{ Debug(format string, args ...interface{}); Error(format string, args ...interface{}); Warn(format string, args ...interface{}) }) (string, error) {
+func ListFiles(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+	log := env.Logger
 	listFilesInput := ListFilesInput{}
 	err := json.Unmarshal(input, &listFilesInput)
 	if err != nil {
 		return "", fmt.Errorf("invalid input: %w", err)
 	}
 
-	dir := "."
-	if listFilesInput.Path != "" {
-		dir = listFilesInput.Path
+	dir, err := env.Workspace.ResolveRead(listFilesInput.Path)
+	if err != nil {
+		log.Warn("Rejected listing of %s: %v", listFilesInput.Path, err)
+		return "", err
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", listFilesInput.Path)
+	}
+
+	limit := listFilesInput.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	var pattern *globMatcher
+	if listFilesInput.Pattern != "" {
+		if pattern, err = newGlobMatcher(listFilesInput.Pattern); err != nil {
+			return "", err
+		}
+	}
+
+	var ignore *ignoreMatcher
+	prefix := ""
+	if !listFilesInput.IncludeIgnored {
+		ignore, prefix = workspaceIgnoreMatcher(env.Workspace, dir)
 	}
 
 	log.Debug("Listing files in directory: %s", dir)
 
-	var files []string
-	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	var entries []listEntry
+	total := 0
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
+			if p == dir {
+				return err
+			}
+			log.Warn("Skipping %s: %v", p, err)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		relPath, err := filepath.Rel(dir, path)
-		if err != nil {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
 			return err
 		}
+		rel = filepath.ToSlash(rel)
 
-		if relPath != "." {
-			relPath = filepath.ToSlash(relPath)
-			if info.IsDir() {
-				files = append(files, relPath+"/")
-			} else {
-				files = append(files, relPath)
+		if d.IsDir() && alwaysIgnored[d.Name()] || ignore != nil && ignore.ignored(path.Join(prefix, rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
 
+		descend := true
+		if d.IsDir() {
+			if listFilesInput.MaxDepth > 0 && strings.Count(rel, "/")+1 >= listFilesInput.MaxDepth {
+				descend = false
+			} else if ignore != nil {
+				ignore.loadDir(path.Join(prefix, rel))
+			}
+		}
+
+		if pattern == nil || !d.IsDir() && pattern.Match(rel) {
+			total++
+			if len(entries) < limit {
+				entry := listEntry{path: rel, dir: d.IsDir()}
+				if info, err := d.Info(); err == nil && !d.IsDir() {
+					entry.size = info.Size()
+				}
+				entries = append(entries, entry)
+			}
+		}
+
+		if !descend {
+			return filepath.SkipDir
+		}
 		return nil
 	})
 	if err != nil {
@@ -168,88 +499,253 @@ func ListFiles(input json.RawMessage, log interface{ Debug(format string, args .
 		return "", err
 	}
 
-	log.Debug("Successfully listed %d items in %s", len(files), dir)
+	log.Debug("Successfully listed %d of %d items in %s", len(entries), total, dir)
 
-	result, err := json.Marshal(files)
-	if err != nil {
-		return "", err
+	var result string
+	if listFilesInput.Tree {
+		result = renderTree(entries)
+	} else {
+		files := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if entry.dir {
+				files = append(files, entry.path+"/")
+			} else {
+				files = append(files, entry.path)
+			}
+		}
+		data, err := json.Marshal(files)
+		if err != nil {
+			return "", err
+		}
+		result = string(data)
+	}
+
+	if total > len(entries) {
+		result += fmt.Sprintf("\n... (showing %d of %d entries; narrow the listing with path, max_depth or pattern)", len(entries), total)
+	}
+	return result, nil
+}
+
+// renderTree indents entries by depth and adds file sizes. Directories that
+// were not listed themselves, e.g. when filtering by pattern, are inserted
+// above their files.
+func renderTree(entries []listEntry) string {
+	var b strings.Builder
+	shown := make(map[string]bool)
+
+	for _, entry := range entries {
+		parts := strings.Split(entry.path, "/")
+		for i := 1; i < len(parts); i++ {
+			parent := strings.Join(parts[:i], "/")
+			if !shown[parent] {
+				shown[parent] = true
+				fmt.Fprintf(&b, "%s%s/\n", strings.Repeat("  ", i-1), parts[i-1])
+			}
+		}
+
+		indent := strings.Repeat("  ", len(parts)-1)
+		name := parts[len(parts)-1]
+		if entry.dir {
+			shown[entry.path] = true
+			fmt.Fprintf(&b, "%s%s/\n", indent, name)
+		} else {
+			fmt.Fprintf(&b, "%s%s (%s)\n", indent, name, formatSize(entry.size))
+		}
+	}
+
+	if b.Len() == 0 {
+		return "(no files)"
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
 	}
+}
 
-	return string(result), nil
+func Bash(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+	return runShell(ctx, env, input, Shells[DefaultShell])
 }
 
-func Bash(input json.RawMessage, log interface{ Debug(format string, args ...interface{}); Error(format string, args ...interface{}); Warn(format string, args ...interface{}) }) (string, error) {
+func runShell(ctx context.Context, env *Env, input json.RawMessage, shell []string) (string, error) {
+	log := env.Logger
 	bashInput := BashInput{}
 	err := json.Unmarshal(input, &bashInput)
 	if err != nil {
 		return "", err
 	}
 
-	log.Debug("Executing bash command: %s", bashInput.Command)
-	cmd := exec.Command("nu", "-c", bashInput.Command)
-	output, err := cmd.CombinedOutput()
+	dir, err := env.Workspace.Resolve(bashInput.WorkingDir)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("working directory %s does not exist", bashInput.WorkingDir)
+	}
+
+	log.Debug("Executing %s command in %s: %s", shell[0], dir, bashInput.Command)
+	cmd := exec.CommandContext(ctx, shell[0], append(shell[1:], bashInput.Command)...)
+	cmd.Dir = dir
+	configureCommand(cmd)
+
+	if len(bashInput.Env) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range bashInput.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+
+	// Output is shown live while the command runs and returned once it ends
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdout, env.Output)
+	cmd.Stderr = io.MultiWriter(&stderr, env.Output)
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		log.Warn("Bash command cancelled: %s", bashInput.Command)
+		return "", fmt.Errorf("command cancelled: %w", ctx.Err())
+	}
+
+	result := BashResult{
+		Stdout: truncateOutput(stdout.String(), maxShellOutputBytes),
+		Stderr: truncateOutput(stderr.String(), maxShellOutputBytes),
+	}
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			log.Error("Failed to run %s: %v", shell[0], err)
+			return "", fmt.Errorf("failed to run command with %s: %w", shell[0], err)
+		}
+		result.ExitCode = exitErr.ExitCode()
+		log.Warn("Bash command failed: %s, exit code: %d", bashInput.Command, result.ExitCode)
+	} else {
+		log.Debug("Bash command succeeded: %s (stdout: %d bytes, stderr: %d bytes)", bashInput.Command, stdout.Len(), stderr.Len())
+	}
+
+	data, err := json.Marshal(result)
 	if err != nil {
-		log.Warn("Bash command failed: %s, error: %v", bashInput.Command, err)
-		return fmt.Sprintf("Command failed with error: %s\nOutput: %s", err.Error(), string(output)), nil
+		return "", err
+	}
+	return string(data), nil
+}
+
+// truncateOutput keeps the start and end of output longer than limit bytes,
+// where compiler errors and test summaries usually are.
+func truncateOutput(output string, limit int) string {
+	if len(output) <= limit {
+		return output
 	}
 
-	log.Debug("Bash command succeeded: %s (output: %d bytes)", bashInput.Command, len(output))
-	return strings.TrimSpace(string(output)), nil
+	half := limit / 2
+	head := strings.ToValidUTF8(output[:half], "")
+	tail := strings.ToValidUTF8(output[len(output)-half:], "")
+	return fmt.Sprintf("%s\n... (%d bytes omitted) ...\n%s", head, len(output)-2*half, tail)
 }
 
-func EditFile(input json.RawMessage, log interface{ Debug(format string, args ...interface{}); Error(format string, args ...interface{}); Warn(format string, args ...interface{}) }) (string, error) {
+func EditFile(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+	log := env.Logger
 	editFileInput := EditFileInput{}
 	err := json.Unmarshal(input, &editFileInput)
 	if err != nil {
 		return "", err
 	}
 
-	if editFileInput.Path == "" || editFileInput.OldStr == editFileInput.NewStr {
+	edits := editFileInput.Edits
+	if editFileInput.OldStr != "" || editFileInput.NewStr != "" {
+		edits = append([]Edit{{OldStr: editFileInput.OldStr, NewStr: editFileInput.NewStr}}, edits...)
+	}
+	if editFileInput.Path == "" || len(edits) == 0 {
 		log.Error("EditFile failed: invalid input parameters")
-		return "", fmt.Errorf("invalid input parameters")
+		return "", fmt.Errorf("invalid input parameters: path and at least one edit are required")
+	}
+	for i, edit := range edits {
+		if edit.OldStr == edit.NewStr {
+			return "", fmt.Errorf("edit %d: old_str and new_str must be different", i+1)
+		}
 	}
 
-	log.Debug("Editing file: %s (replacing %d chars with %d chars)", editFileInput.Path, len(editFileInput.OldStr), len(editFileInput.NewStr))
-	content, err := os.ReadFile(editFileInput.Path)
+	path, err := env.Workspace.ResolveWrite(editFileInput.Path)
 	if err != nil {
-		if os.IsNotExist(err) && editFileInput.OldStr == "" {
-			log.Debug("File does not exist, creating new file: %s", editFileInput.Path)
-			return createNewFile(editFileInput.Path, editFileInput.NewStr, log)
+		log.Warn("Rejected edit of %s: %v", editFileInput.Path, err)
+		return "", err
+	}
+	displayPath := env.Workspace.Rel(path)
+
+	log.Debug("Editing file: %s (%d edits)", editFileInput.Path, len(edits))
+	exists := true
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("Failed to read file %s: %v", editFileInput.Path, err)
+			return "", err
 		}
-		log.Error("Failed to read file %s: %v", editFileInput.Path, err)
+		exists = false
 	}
 	oldContent := string(content)
 
-	// Special case: if old_str is empty, we're appending to the file
-	var newContent string
-	if editFileInput.OldStr == "" {
-		newContent = oldContent + editFileInput.NewStr
-	} else {
+	// Apply every edit in memory first so a failing one leaves the file untouched
+	newContent := oldContent
+	for i, edit := range edits {
+		// Special case: if old_str is empty, we're appending to the file
+		if edit.OldStr == "" {
+			newContent += edit.NewStr
+			continue
+		}
+		if !exists {
+			return "", fmt.Errorf("file %s does not exist; use an empty old_str to create it", editFileInput.Path)
+		}
+
 		// count occurrences first to ensure we have exactly one match
-		count := strings.Count(oldContent, editFileInput.OldStr)
+		count := strings.Count(newContent, edit.OldStr)
 		if count == 0 {
-			log.Error("EditFile failed: old_str not found in file %s", editFileInput.Path)
-			return "", fmt.Errorf("old_str not found in file")
+			log.Error("EditFile failed: old_str of edit %d not found in file %s", i+1, editFileInput.Path)
+			return "", fmt.Errorf("edit %d of %d: old_str not found in file; no changes were made", i+1, len(edits))
 		}
 		if count > 1 {
-			log.Error("EditFile failed: old_str found %d times in file %s, must be unique", count, editFileInput.Path)
-			return "", fmt.Errorf("old_str found %d times in file, must be unique", count)
+			log.Error("EditFile failed: old_str of edit %d found %d times in file %s, must be unique", i+1, count, editFileInput.Path)
+			return "", fmt.Errorf("edit %d of %d: old_str found %d times in file, must be unique; no changes were made", i+1, len(edits), count)
 		}
 
-		newContent = strings.Replace(oldContent, editFileInput.OldStr, editFileInput.NewStr, 1)
+		newContent = strings.Replace(newContent, edit.OldStr, edit.NewStr, 1)
 	}
 
-	err = os.WriteFile(editFileInput.Path, []byte(newContent), 0644)
+	if !exists {
+		log.Debug("File does not exist, creating new file: %s", editFileInput.Path)
+		result, err := createNewFile(path, displayPath, newContent, log)
+		if err == nil {
+			env.recordChange(path, nil, &newContent, 0)
+		}
+		return result, err
+	}
+
+	err = writeFileAtomic(path, []byte(newContent), 0644)
 	if err != nil {
 		log.Error("Failed to write file %s: %v", editFileInput.Path, err)
 		return "", err
 	}
+	env.recordChange(path, &oldContent, &newContent, 0)
 
 	log.Debug("Successfully edited file %s", editFileInput.Path)
-	return "OK", nil
+	diff := UnifiedDiff("a/"+displayPath, "b/"+displayPath, oldContent, newContent)
+	return fmt.Sprintf("Edited %s:\n%s", displayPath, truncateOutput(diff, maxShellOutputBytes)), nil
 }
 
-func CodeSearch(input json.RawMessage, log interface{ Debug(format string, args ...interface{}); Error(format string, args ...interface{}); Warn(format string, args ...interface{}) }) (string, error) {
+// Limits of code_search results
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+	maxSearchContext   = 10
+)
+
+func CodeSearch(ctx context.Context, env *Env, input json.RawMessage) (string, error) {
+	log := env.Logger
 	codeSearchInput := CodeSearchInput{}
 	err := json.Unmarshal(input, &codeSearchInput)
 	if err != nil {
@@ -260,63 +756,74 @@ func CodeSearch(input json.RawMessage, log interface{ Debug(format string, args
 		log.Error("CodeSearch failed: pattern is required")
 		return "", fmt.Errorf("pattern is required")
 	}
+	codeSearchInput.ContextBefore = min(max(codeSearchInput.ContextBefore, 0), maxSearchContext)
+	codeSearchInput.ContextAfter = min(max(codeSearchInput.ContextAfter, 0), maxSearchContext)
 
 	log.Debug("Searching for pattern: %s", codeSearchInput.Pattern)
 
-	// Build ripgrep command
-	args := []string{"rg", "--line-number", "--with-filename", "--color=never"}
-
-	// Add case sensitivity flag
-	if !codeSearchInput.CaseSensitive {
-		args = append(args, "--ignore-case")
-	}
-
-	// Add file type filter if specified
-	if codeSearchInput.FileType != "" {
-		args = append(args, "--type", codeSearchInput.FileType)
+	target := "."
+	if codeSearchInput.Path != "" {
+		path, err := env.Workspace.ResolveRead(codeSearchInput.Path)
+		if err != nil {
+			log.Warn("Rejected search in %s: %v", codeSearchInput.Path, err)
+			return "", err
+		}
+		target = env.Workspace.Rel(path)
 	}
 
-	// Add pattern
-	args = append(args, codeSearchInput.Pattern)
-
-	// Add path if specified
-	if codeSearchInput.Path != "" {
-		args = append(args, codeSearchInput.Path)
+	var matches []SearchMatch
+	if _, lookErr := exec.LookPath("rg"); lookErr == nil {
+		matches, err = ripgrep(ctx, env, codeSearchInput, target)
 	} else {
-		args = append(args, ".")
+		log.Debug("ripgrep not found, using built-in search")
+		matches, err = searchFiles(ctx, env, codeSearchInput, target)
+	}
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("search cancelled: %w", ctx.Err())
 	}
-
-	log.Debug("Executing ripgrep with args: %v", args)
-
-	cmd := exec.Command(args[0], args[1:]...)
-	output, err := cmd.Output()
-
-	// ripgrep returns exit code 1 when no matches are found, which is not an error
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == 1 {
-			log.Debug("No matches found for pattern: %s", codeSearchInput.Pattern)
-			return "No matches found", nil
-		}
-		log.Error("Ripgrep command failed: %v", err)
+		log.Error("Search failed: %v", err)
 		return "", fmt.Errorf("search failed: %w", err)
 	}
 
-	result := strings.TrimSpace(string(output))
-	lines := strings.Split(result, "\n")
+	log.Debug("Found %d matches for pattern: %s", len(matches), codeSearchInput.Pattern)
 
-	log.Debug("Found %d matches for pattern: %s", len(lines), codeSearchInput.Pattern)
+	// Return one page of results to prevent overwhelming responses
+	limit := codeSearchInput.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+	offset := min(max(codeSearchInput.Offset, 0), len(matches))
+	page := matches[offset:min(offset+limit, len(matches))]
 
-	// Limit output to prevent overwhelming responses
-	if len(lines) > 50 {
-		result = strings.Join(lines[:50], "\n") + fmt.Sprintf("\n... (showing first 50 of %d matches)", len(lines))
+	result := CodeSearchResult{Total: len(matches), Offset: offset}
+	if next := offset + len(page); next < len(matches) {
+		result.NextOffset = next
+	}
+	if codeSearchInput.FilesWithMatches {
+		for _, match := range page {
+			result.Files = append(result.Files, match.Path)
+		}
+	} else {
+		result.Matches = page
 	}
 
-	return result, nil
+	// Code is full of <, > and &, which must reach the model unescaped
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(result); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
 }
 
-func createNewFile(filePath, content string, log interface{ Debug(format string, args ...interface{}); Error(format string, args ...interface{}); Warn(format string, args ...interface{}) }) (string, error) {
-	log.Debug("Creating new file: %s (%d bytes)", filePath, len(content))
-	dir := path.Dir(filePath)
+// createNewFile writes a file at the resolved filePath; displayPath is the
+// path as given by the model.
+func createNewFile(filePath, displayPath, content string, log Logger) (string, error) {
+	log.Debug("Creating new file: %s (%d bytes)", displayPath, len(content))
+	dir := filepath.Dir(filePath)
 	if dir != "." {
 		log.Debug("Creating directory: %s", dir)
 		err := os.MkdirAll(dir, 0755)
@@ -326,14 +833,14 @@ func createNewFile(filePath, content string, log interface{ Debug(format string,
 		}
 	}
 
-	err := os.WriteFile(filePath, []byte(content), 0644)
+	err := writeFileAtomic(filePath, []byte(content), 0644)
 	if err != nil {
 		log.Error("Failed to write file %s: %v", filePath, err)
 		return "", fmt.Errorf("failed to write file: %w", err)
 	}
 
-	log.Debug("Successfully created file %s", filePath)
-	return fmt.Sprintf("Successfully created file %s", filePath), nil
+	log.Debug("Successfully created file %s", displayPath)
+	return fmt.Sprintf("Successfully created file %s", displayPath), nil
 }
 
 func GenerateSchema[T any]() openai.FunctionParameters {
@@ -0,0 +1,196 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns a file of n lines reading "line 1" to "line n".
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestReadFile(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"ten.txt":   numberedLines(10),
+		"crlf.txt":  "first\r\nsecond\r\n",
+		"empty.txt": "",
+		"big.txt":   numberedLines(20000),
+	})
+	if err := os.WriteFile(filepath.Join(root, "image.png"), []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{WorkspaceRoot: root, Workspace: NewWorkspace(root), Logger: nopLogger{}}
+
+	read := func(input ReadFileInput) (string, error) {
+		data, _ := json.Marshal(input)
+		return ReadFile(context.Background(), env, data)
+	}
+
+	t.Run("whole file", func(t *testing.T) {
+		out, err := read(ReadFileInput{Path: "ten.txt"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(out, "\n"); lines != 10 || strings.Contains(out, "showing lines") {
+			t.Errorf("got %d lines:\n%s", lines, out)
+		}
+		if !strings.HasPrefix(out, "     1\tline 1\n") {
+			t.Errorf("lines are not numbered:\n%s", out)
+		}
+	})
+
+	t.Run("offset and limit", func(t *testing.T) {
+		out, err := read(ReadFileInput{Path: "ten.txt", Offset: 4, Limit: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "     4\tline 4\n     5\tline 5\n     6\tline 6\n" +
+			"... (showing lines 4-6 of 10; call read_file with offset=7 to read more)\n"
+		if out != want {
+			t.Errorf("got:\n%s\nwant:\n%s", out, want)
+		}
+	})
+
+	t.Run("limit reaching the end", func(t *testing.T) {
+		out, err := read(ReadFileInput{Path: "ten.txt", Offset: 9, Limit: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "     9\tline 9\n    10\tline 10\n" {
+			t.Errorf("got:\n%s", out)
+		}
+	})
+
+	t.Run("carriage returns are dropped", func(t *testing.T) {
+		out, err := read(ReadFileInput{Path: "crlf.txt"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "     1\tfirst\n     2\tsecond\n" {
+			t.Errorf("got %q", out)
+		}
+	})
+
+	t.Run("truncated at 64KB", func(t *testing.T) {
+		out, err := read(ReadFileInput{Path: "big.txt", Limit: 20000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) > maxReadBytes+200 {
+			t.Errorf("output is %d bytes", len(out))
+		}
+		note := out[strings.LastIndex(strings.TrimSuffix(out, "\n"), "\n")+1:]
+		var last, next int
+		if _, err := fmt.Sscanf(note, "... (showing lines 1-%d of 20000; call read_file with offset=%d to read more)", &last, &next); err != nil {
+			t.Fatalf("missing truncation note, output ends with %q", note)
+		}
+		if next != last+1 || !strings.Contains(out, fmt.Sprintf("\tline %d\n", last)) {
+			t.Errorf("note says lines 1-%d, offset %d", last, next)
+		}
+
+		// the suggested offset continues exactly where the output stopped
+		rest, err := read(ReadFileInput{Path: "big.txt", Offset: next, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(rest, fmt.Sprintf("%6d\tline %d\n", next, next)) {
+			t.Errorf("next page starts with %q", rest)
+		}
+	})
+
+	t.Run("binary file", func(t *testing.T) {
+		if out, err := read(ReadFileInput{Path: "image.png"}); err == nil || !strings.Contains(err.Error(), "binary") {
+			t.Errorf("got %q, %v, want a binary file error", out, err)
+		}
+	})
+
+	t.Run("offset past the end", func(t *testing.T) {
+		_, err := read(ReadFileInput{Path: "ten.txt", Offset: 11})
+		if err == nil || !strings.Contains(err.Error(), "past the end of the file, which has 10 lines") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		if out, err := read(ReadFileInput{Path: "empty.txt", Offset: 5}); err != nil || out != "(empty file)" {
+			t.Errorf("got %q, %v", out, err)
+		}
+	})
+
+	t.Run("negative offset", func(t *testing.T) {
+		if _, err := read(ReadFileInput{Path: "ten.txt", Offset: -1}); err == nil {
+			t.Error("no error for a negative offset")
+		}
+	})
+}
+
+func TestEditFile(t *testing.T) {
+	root := t.TempDir()
+	env := &Env{WorkspaceRoot: root, Workspace: NewWorkspace(root), Logger: nopLogger{}}
+	file := filepath.Join(root, "run.sh")
+	original := "#!/bin/sh\r\necho one\r\necho two\r\necho one\r\n"
+
+	edit := func(input EditFileInput) (string, error) {
+		if err := os.WriteFile(file, []byte(original), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(file, 0755); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(input)
+		return EditFile(context.Background(), env, data)
+	}
+	unchanged := func(name string) {
+		t.Helper()
+		if data, _ := os.ReadFile(file); string(data) != original {
+			t.Errorf("%s: file changed to %q", name, data)
+		}
+	}
+
+	// a failing later edit leaves the file byte for byte as it was
+	_, err := edit(EditFileInput{Path: "run.sh", Edits: []Edit{
+		{OldStr: "echo two", NewStr: "echo 2"},
+		{OldStr: "echo three", NewStr: "echo 3"},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "edit 2 of 2: old_str not found") {
+		t.Errorf("missing second edit: err = %v", err)
+	}
+	unchanged("missing second edit")
+
+	_, err = edit(EditFileInput{Path: "run.sh", OldStr: "echo one", NewStr: "echo 1"})
+	if err == nil || !strings.Contains(err.Error(), "found 2 times in file, must be unique") {
+		t.Errorf("ambiguous edit: err = %v", err)
+	}
+	unchanged("ambiguous edit")
+
+	// edits see the result of the ones before them
+	_, err = edit(EditFileInput{Path: "run.sh", Edits: []Edit{
+		{OldStr: "echo two\r\necho one", NewStr: "echo two\r\necho last"},
+		{OldStr: "echo one", NewStr: "echo first"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(file)
+	if want := "#!/bin/sh\r\necho first\r\necho two\r\necho last\r\n"; string(data) != want {
+		t.Errorf("content = %q, want %q", data, want)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %v, want 0755", info.Mode().Perm())
+	}
+}
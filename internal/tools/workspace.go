@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrOutsideWorkspace is returned for paths that resolve outside every
+	// root a tool may access.
+	ErrOutsideWorkspace = errors.New("path is outside the workspace")
+	// ErrReadOnlyPath is returned when writing under a read-only root.
+	ErrReadOnlyPath = errors.New("path is read-only")
+)
+
+// Workspace confines file tools to a root directory. Additional roots can be
+// opened for reading only, e.g. the Go module cache.
+type Workspace struct {
+	root          string
+	readOnlyRoots []string
+}
+
+// NewWorkspace returns a workspace rooted at root. Roots are made absolute and
+// their symlinks resolved so that containment checks compare real paths.
+func NewWorkspace(root string, readOnlyRoots ...string) *Workspace {
+	if root == "" {
+		root = "."
+	}
+
+	w := &Workspace{root: canonicalRoot(root)}
+	for _, dir := range readOnlyRoots {
+		if dir != "" {
+			w.readOnlyRoots = append(w.readOnlyRoots, canonicalRoot(dir))
+		}
+	}
+	return w
+}
+
+func (w *Workspace) Root() string {
+	return w.root
+}
+
+func (w *Workspace) ReadOnlyRoots() []string {
+	return w.readOnlyRoots
+}
+
+// Resolve returns the real path of p, relative paths being interpreted
+// against the workspace root, if it is inside the workspace root.
+func (w *Workspace) Resolve(p string) (string, error) {
+	resolved, err := w.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	if !within(w.root, resolved) {
+		return "", w.outsideError(p, resolved)
+	}
+	return resolved, nil
+}
+
+// ResolveRead is like Resolve but also accepts paths under read-only roots.
+func (w *Workspace) ResolveRead(p string) (string, error) {
+	resolved, err := w.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	if within(w.root, resolved) {
+		return resolved, nil
+	}
+	for _, dir := range w.readOnlyRoots {
+		if within(dir, resolved) {
+			return resolved, nil
+		}
+	}
+	return "", w.outsideError(p, resolved)
+}
+
+// ResolveWrite is like Resolve but reports writes under read-only roots
+// as such rather than as escapes.
+func (w *Workspace) ResolveWrite(p string) (string, error) {
+	resolved, err := w.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	if within(w.root, resolved) {
+		return resolved, nil
+	}
+	for _, dir := range w.readOnlyRoots {
+		if within(dir, resolved) {
+			return "", fmt.Errorf("%w: %s is under the read-only root %s and cannot be modified", ErrReadOnlyPath, p, dir)
+		}
+	}
+	return "", w.outsideError(p, resolved)
+}
+
+// Rel returns p relative to the workspace root for display, or p itself if
+// it is not under the root.
+func (w *Workspace) Rel(p string) string {
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil || !within(w.root, p) {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (w *Workspace) resolve(p string) (string, error) {
+	if p == "" {
+		p = "."
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(w.root, p)
+	}
+	return evalExisting(filepath.Clean(p))
+}
+
+// outsideError mentions the resolved path only when a symlink led outside.
+func (w *Workspace) outsideError(p, resolved string) error {
+	lexical := p
+	if !filepath.IsAbs(lexical) {
+		lexical = filepath.Join(w.root, lexical)
+	}
+	if filepath.Clean(lexical) != resolved {
+		return fmt.Errorf("%w: %s resolves to %s, which is not under the workspace root %s", ErrOutsideWorkspace, p, resolved, w.root)
+	}
+	return fmt.Errorf("%w: %s is not under the workspace root %s", ErrOutsideWorkspace, p, w.root)
+}
+
+// evalExisting resolves symlinks in the longest existing prefix of p, so that
+// paths of files that do not exist yet can be checked as well.
+func evalExisting(p string) (string, error) {
+	rest := ""
+	current := p
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		// A dangling symlink would be followed when the file is created
+		if _, lerr := os.Lstat(current); lerr == nil {
+			return "", fmt.Errorf("%s is a symlink to a missing target", current)
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return p, nil
+		}
+		rest = filepath.Join(filepath.Base(current), rest)
+		current = parent
+	}
+}
+
+func canonicalRoot(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if resolved, err := evalExisting(dir); err == nil {
+		dir = resolved
+	}
+	return filepath.Clean(dir)
+}
+
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
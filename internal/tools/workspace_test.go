@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspaceResolve(t *testing.T) {
+	parent := t.TempDir()
+	for _, dir := range []string{"ws/sub", "ws-other", "outside", "readonly"} {
+		if err := os.MkdirAll(filepath.Join(parent, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, parent, map[string]string{
+		"ws/sub/a.txt":    "a",
+		"outside/secret":  "secret",
+		"readonly/lib.go": "package lib",
+		"ws-other/b.txt":  "b",
+	})
+	links := map[string]string{
+		"ws/escape":       filepath.Join(parent, "outside"),
+		"ws/secret":       filepath.Join(parent, "outside", "secret"),
+		"ws/inner":        "sub",
+		"ws/dangling":     "missing.txt",
+		"ws/dangling-out": filepath.Join(parent, "outside", "missing"),
+		"ws/lib":          filepath.Join(parent, "readonly"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(parent, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := NewWorkspace(filepath.Join(parent, "ws"), filepath.Join(parent, "readonly"))
+	root := w.Root()
+	readOnly := w.ReadOnlyRoots()[0]
+	outside := filepath.Join(filepath.Dir(root), "outside")
+
+	const (
+		resolve = "resolve"
+		read    = "read"
+		write   = "write"
+	)
+	tests := []struct {
+		method  string
+		path    string
+		want    string
+		wantErr error
+		// anyErr accepts an error that wraps neither sentinel
+		anyErr bool
+	}{
+		{resolve, "", root, nil, false},
+		{resolve, ".", root, nil, false},
+		{resolve, root, root, nil, false},
+		{write, ".", root, nil, false},
+		{resolve, "sub/a.txt", filepath.Join(root, "sub", "a.txt"), nil, false},
+		{resolve, "sub/../sub/a.txt", filepath.Join(root, "sub", "a.txt"), nil, false},
+		{resolve, "inner/a.txt", filepath.Join(root, "sub", "a.txt"), nil, false},
+
+		{resolve, "../outside/secret", "", ErrOutsideWorkspace, false},
+		{resolve, "sub/../../outside/secret", "", ErrOutsideWorkspace, false},
+		{resolve, "..", "", ErrOutsideWorkspace, false},
+		{resolve, "../ws-other/b.txt", "", ErrOutsideWorkspace, false},
+		{resolve, filepath.Join(outside, "secret"), "", ErrOutsideWorkspace, false},
+		{read, "/etc/passwd", "", ErrOutsideWorkspace, false},
+
+		{resolve, "escape/secret", "", ErrOutsideWorkspace, false},
+		{read, "secret", "", ErrOutsideWorkspace, false},
+		{write, "escape", "", ErrOutsideWorkspace, false},
+
+		{resolve, "dangling", "", nil, true},
+		{write, "dangling", "", nil, true},
+		{write, "dangling-out", "", nil, true},
+
+		{write, "sub/new/file.txt", filepath.Join(root, "sub", "new", "file.txt"), nil, false},
+		{write, "inner/new/file.txt", filepath.Join(root, "sub", "new", "file.txt"), nil, false},
+		{write, "escape/new.txt", "", ErrOutsideWorkspace, false},
+		{write, "escape/new/deeper/file.txt", "", ErrOutsideWorkspace, false},
+
+		{resolve, "lib/lib.go", "", ErrOutsideWorkspace, false},
+		{read, "lib/lib.go", filepath.Join(readOnly, "lib.go"), nil, false},
+		{read, filepath.Join(readOnly, "lib.go"), filepath.Join(readOnly, "lib.go"), nil, false},
+		{write, "lib/lib.go", "", ErrReadOnlyPath, false},
+		{write, "lib/new.go", "", ErrReadOnlyPath, false},
+	}
+
+	for _, tt := range tests {
+		var got string
+		var err error
+		switch tt.method {
+		case resolve:
+			got, err = w.Resolve(tt.path)
+		case read:
+			got, err = w.ResolveRead(tt.path)
+		case write:
+			got, err = w.ResolveWrite(tt.path)
+		}
+
+		switch {
+		case tt.wantErr != nil:
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s %q: got %q, %v, want %v", tt.method, tt.path, got, err, tt.wantErr)
+			}
+		case tt.anyErr:
+			if err == nil {
+				t.Errorf("%s %q: got %q, want an error", tt.method, tt.path, got)
+			}
+		case err != nil || got != tt.want:
+			t.Errorf("%s %q: got %q, %v, want %q", tt.method, tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestEvalExisting(t *testing.T) {
+	root := NewWorkspace(t.TempDir()).Root()
+	writeFiles(t, root, map[string]string{"dir/file.txt": "x"})
+	if err := os.Symlink("dir", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("nowhere", filepath.Join(root, "broken")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"dir/file.txt", "dir/file.txt", false},
+		{"link/file.txt", "dir/file.txt", false},
+		{"link/a/b/c.txt", "dir/a/b/c.txt", false},
+		{"missing/a.txt", "missing/a.txt", false},
+		{"broken", "", true},
+		{"broken/a.txt", "", true},
+	}
+	for _, tt := range tests {
+		got, err := evalExisting(filepath.Join(root, tt.path))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("evalExisting(%q) = %q, want an error", tt.path, got)
+			}
+			continue
+		}
+		if want := filepath.Join(root, tt.want); err != nil || got != want {
+			t.Errorf("evalExisting(%q) = %q, %v, want %q", tt.path, got, err, want)
+		}
+	}
+}